@@ -0,0 +1,59 @@
+package assembler
+
+import (
+	"bytes"
+	"testing"
+)
+
+// runs both passes over lines and returns the image
+func assemble_image(t *testing.T, lines []string) []byte {
+	t.Helper()
+	bin, err := Assemble(lines)
+	if err != nil {
+		t.Fatalf("%q: %v", lines, err)
+	}
+	return bin
+}
+
+func TestAlign(t *testing.T) {
+	cases := []struct {
+		lines []string
+		want  []string // lines that assemble to the same image
+	}{
+		{[]string{"addi a0, a0, 1", ".align 3", "addi a0, a0, 2"}, []string{"addi a0, a0, 1", "addi zero, zero, 0", "addi a0, a0, 2"}},
+		{[]string{"addi a0, a0, 1", ".p2align 3", "addi a0, a0, 2"}, []string{"addi a0, a0, 1", "addi zero, zero, 0", "addi a0, a0, 2"}},
+		{[]string{"addi a0, a0, 1", ".balign 8", "addi a0, a0, 2"}, []string{"addi a0, a0, 1", "addi zero, zero, 0", "addi a0, a0, 2"}},
+		{[]string{"addi a0, a0, 1", ".balign 8, 0xff", "addi a0, a0, 2"}, []string{"addi a0, a0, 1", ".byte 0xff, 0xff, 0xff, 0xff", "addi a0, a0, 2"}},
+		{[]string{"addi a0, a0, 1", ".align 2", "addi a0, a0, 2"}, []string{"addi a0, a0, 1", "addi a0, a0, 2"}},      // already aligned
+		{[]string{"addi a0, a0, 1", ".align 0", "addi a0, a0, 2"}, []string{"addi a0, a0, 1", "addi a0, a0, 2"}},      // 1 byte
+		{[]string{"addi a0, a0, 1", ".p2align 4,,4", "addi a0, a0, 2"}, []string{"addi a0, a0, 1", "addi a0, a0, 2"}}, // needs 12 > max skip
+		{[]string{"addi a0, a0, 1", ".p2align 4,,12", "addi a0, a0, 2"}, []string{"addi a0, a0, 1", "addi zero, zero, 0", "addi zero, zero, 0", "addi zero, zero, 0", "addi a0, a0, 2"}},
+		{[]string{".byte 1", ".align 3", "addi a0, a0, 2"}, []string{".byte 1, 0, 0, 0", "addi zero, zero, 0", "addi a0, a0, 2"}}, // zeros up to an instruction boundary
+		{[]string{".data", ".byte 1", ".balign 4, 0xaa", ".byte 2"}, []string{".data", ".byte 1, 0xaa, 0xaa, 0xaa, 2"}},
+		{[]string{".data", ".byte 1", ".align 2", ".byte 2"}, []string{".data", ".byte 1, 0, 0, 0, 2"}},
+		{[]string{".data", ".byte 1", ".balign 4, 0xaa, 2", ".byte 2"}, []string{".data", ".byte 1, 2"}},
+		{[]string{".data", ".byte 1", ".balign 4, 0xaa, 3", ".byte 2"}, []string{".data", ".byte 1, 0xaa, 0xaa, 0xaa, 2"}},
+	}
+	for _, c := range cases {
+		got, want := assemble_image(t, c.lines), assemble_image(t, c.want)
+		if !bytes.Equal(got, want) {
+			t.Errorf("%q:\n got %x\nwant %x", c.lines, got, want)
+		}
+	}
+}
+
+// a section starts at its largest alignment
+func TestSectionAlign(t *testing.T) {
+	FirstPass([]string{"addi zero, zero, 0", ".data", ".balign 64", ".word 1", ".bss", ".p2align 5", ".space 4"})
+	if data, bss := sectionTable[".data"], sectionTable[".bss"]; data.addr != 64 || bss.addr != 96 {
+		t.Errorf(".data at %d, .bss at %d, want 64 and 96", data.addr, bss.addr)
+	}
+}
+
+func TestAlignRejects(t *testing.T) {
+	for _, line := range []string{".align", ".align 17", ".p2align -1", ".balign 3", ".balign 0", ".align 2, 256", ".align 2, -2", ".align 2, 0, -1", ".align 2, 0, 4, 1", ".align x"} {
+		if _, err := Assemble([]string{line}); err == nil {
+			t.Errorf("%q was accepted", line)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package assembler
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// -march: the base ISA plus the extensions a program may use, as a canonical ISA string (rv32imac_zicsr,
+// rv32g, rv32e). Instructions from an extension outside it are rejected. Custom extensions come from
+// --isa-ext files and have to be named too (rv32i_xmyext)
+var Arch = "rv32i"
+
+// a parsed ISA string
+type isa struct {
+	src   string //as written, for diagnostics
+	xlen  int
+	base  byte //'i' or 'e'
+	exts  InstrExt
+	xexts map[string]bool
+}
+
+// the -march selection, and what the file being assembled uses (.attribute arch can change it)
+var targetISA = isa{src: "rv32i", xlen: 32, base: 'i'}
+var activeISA = targetISA
+
+// standard extension with the version written to .riscv.attributes and what it needs in turn
+type ext_info struct {
+	name    string
+	ext     InstrExt
+	version string
+	implies []string
+}
+
+// in canonical order: single letters as the ISA manual orders them, then z extensions by their second letter
+var standardExts = []ext_info{
+	{"m", ExtM, "2p0", nil},
+	{"a", ExtA, "2p1", nil},
+	{"f", ExtF, "2p2", []string{"zicsr"}},
+	{"d", ExtD, "2p2", []string{"f"}},
+	{"q", ExtQ, "2p2", []string{"d"}},
+	{"c", ExtC, "2p0", nil},
+	{"b", ExtB, "1p0", []string{"zba", "zbb", "zbs"}},
+	{"v", ExtV, "1p0", []string{"d"}},
+	{"h", ExtH, "1p0", []string{"zicsr"}},
+	{"zicond", ExtZicond, "1p0", nil},
+	{"zicsr", ExtZicsr, "2p0", nil},
+	{"zifencei", ExtZifencei, "2p0", nil},
+	{"zfbfmin", ExtZfbfmin, "1p0", []string{"f"}},
+	{"zfh", ExtZfh, "1p0", []string{"zfhmin"}},
+	{"zfhmin", ExtZfhmin, "1p0", []string{"f"}},
+	{"zba", ExtZba, "1p0", nil},
+	{"zbb", ExtZbb, "1p0", nil},
+	{"zbc", ExtZbc, "1p0", nil},
+	{"zbkb", ExtZbkb, "1p0", nil},
+	{"zbkc", ExtZbkc, "1p0", nil},
+	{"zbkx", ExtZbkx, "1p0", nil},
+	{"zbs", ExtZbs, "1p0", nil},
+	{"zkn", ExtZkn, "1p0", []string{"zbkb", "zbkc", "zbkx", "zkne", "zknd", "zknh"}},
+	{"zknd", ExtZknd, "1p0", nil},
+	{"zkne", ExtZkne, "1p0", nil},
+	{"zknh", ExtZknh, "1p0", nil},
+	{"zks", ExtZks, "1p0", []string{"zbkb", "zbkc", "zbkx", "zksed", "zksh"}},
+	{"zksed", ExtZksed, "1p0", nil},
+	{"zksh", ExtZksh, "1p0", nil},
+}
+
+// order single letter extensions have to be given in
+const singleLetterOrder = "mafdqlcbkjtpvnh"
+
+var isaVersion = regexp.MustCompile(`[0-9]+(p[0-9]+)?$`)
+var leadingVersion = regexp.MustCompile(`^[0-9]+(p[0-9]+)?`)
+
+func find_ext(name string) (ext_info, bool) {
+	for _, info := range standardExts {
+		if info.name == name {
+			return info, true
+		}
+	}
+	return ext_info{}, false
+}
+
+// names of the extensions in a set, for diagnostics: "zbb or zbkb"
+func ext_names(exts InstrExt) string {
+	var names []string
+	for _, info := range standardExts {
+		if exts&info.ext != 0 {
+			names = append(names, info.name)
+		}
+	}
+	return strings.Join(names, " or ")
+}
+
+// strips a trailing version (2p0, 1) unless the digits are part of the name
+func strip_isa_version(name string, known func(string) bool) string {
+	if known(name) {
+		return name
+	}
+	return isaVersion.ReplaceAllString(name, "")
+}
+
+func parse_isa(march string) (isa, error) {
+	s := strings.ToLower(strings.TrimSpace(march))
+	a := isa{src: march, xexts: map[string]bool{}}
+	rest, ok := strings.CutPrefix(s, "rv")
+	switch {
+	case ok && strings.HasPrefix(rest, "32"):
+		a.xlen = 32
+	case ok && strings.HasPrefix(rest, "64"):
+		a.xlen = 64
+	default:
+		return a, fmt.Errorf("%q: ISA string has to start with rv32 or rv64", march)
+	}
+	rest = rest[2:]
+	if rest == "" {
+		return a, fmt.Errorf("%q: missing base ISA (i, e or g)", march)
+	}
+	switch rest[0] {
+	case 'i', 'e':
+		a.base = rest[0]
+	case 'g':
+		a.base = 'i'
+		a.exts = ExtM | ExtA | ExtF | ExtD | ExtZicsr | ExtZifencei
+	default:
+		return a, fmt.Errorf("%q: base ISA has to be i, e or g, not %c", march, rest[0])
+	}
+	rest = leadingVersion.ReplaceAllString(rest[1:], "")
+	last := -1 //position in singleLetterOrder of the previous single letter
+	for n, tok := range strings.Split(rest, "_") {
+		if tok == "" && n > 0 {
+			return a, fmt.Errorf("%q: empty extension name", march)
+		}
+		for tok != "" {
+			if strings.ContainsRune("zsx", rune(tok[0])) { //multi-letter, runs to the next _
+				if err := a.add_multi(tok); err != nil {
+					return a, fmt.Errorf("%q: %w", march, err)
+				}
+				break
+			}
+			name := tok[:1]
+			pos := strings.IndexByte(singleLetterOrder, tok[0])
+			info, known := find_ext(name)
+			switch {
+			case !known:
+				return a, fmt.Errorf("%q: unknown extension %s", march, name)
+			case a.exts&info.ext != 0 && pos > last:
+				return a, fmt.Errorf("%q: extension %s given twice", march, name)
+			case pos <= last:
+				return a, fmt.Errorf("%q: extension %s has to come before %c", march, name, singleLetterOrder[last])
+			}
+			last = pos
+			a.exts |= info.ext
+			tok = leadingVersion.ReplaceAllString(tok[1:], "")
+		}
+	}
+	a.add_implied()
+	return a, nil
+}
+
+func (a *isa) add_multi(tok string) error {
+	if tok[0] == 'x' {
+		name := strip_isa_version(tok, func(name string) bool { return customExts[name] })
+		if !customExts[name] {
+			return fmt.Errorf("custom extension %s was not loaded with --isa-ext", name)
+		}
+		if a.xexts[name] {
+			return fmt.Errorf("extension %s given twice", name)
+		}
+		a.xexts[name] = true
+		return nil
+	}
+	name := strip_isa_version(tok, func(name string) bool { _, ok := find_ext(name); return ok })
+	info, ok := find_ext(name)
+	if !ok || len(name) == 1 {
+		return fmt.Errorf("unknown extension %s", name)
+	}
+	if a.exts&info.ext != 0 {
+		return fmt.Errorf("extension %s given twice", name)
+	}
+	a.exts |= info.ext
+	return nil
+}
+
+// d needs f, f needs zicsr, ...
+func (a *isa) add_implied() {
+	for grew := true; grew; {
+		grew = false
+		for _, info := range standardExts {
+			if a.exts&info.ext == 0 {
+				continue
+			}
+			for _, name := range info.implies {
+				if dep, _ := find_ext(name); a.exts&dep.ext == 0 {
+					a.exts |= dep.ext
+					grew = true
+				}
+			}
+		}
+	}
+}
+
+// canonical form with versions, as Tag_RISCV_arch records it: rv32i2p1_m2p0_zicsr2p0
+func (a isa) String() string {
+	parts := []string{fmt.Sprintf("rv%d%c2p1", a.xlen, a.base)}
+	if a.base == 'e' {
+		parts[0] = fmt.Sprintf("rv%de2p0", a.xlen)
+	}
+	for _, info := range standardExts {
+		if a.exts&info.ext != 0 {
+			parts = append(parts, info.name+info.version)
+		}
+	}
+	xexts := make([]string, 0, len(a.xexts))
+	for name := range a.xexts {
+		xexts = append(xexts, name+"1p0")
+	}
+	sort.Strings(xexts)
+	return strings.Join(append(parts, xexts...), "_")
+}
+
+// an ISA string the assembler can target
+func check_isa(march string) (isa, error) {
+	a, err := parse_isa(march)
+	if err != nil {
+		return a, err
+	}
+	if a.xlen != 32 {
+		return a, fmt.Errorf("%q: only RV32 is supported", march)
+	}
+	return a, nil
+}
+
+// SetArch checks an -march string and makes it the current one
+func SetArch(march string) error {
+	a, err := check_isa(march)
+	if err != nil {
+		return fmt.Errorf("-march: %w", err)
+	}
+	Arch, targetISA, activeISA = march, a, a
+	return nil
+}
+
+// whether the current ISA includes an extension that provides the instruction
+func instr_enabled(desc InstrDesc) bool {
+	return (desc.ext == ExtNone || activeISA.exts&desc.ext != 0) && activeISA.exts&desc.needs == desc.needs &&
+		(desc.xext == "" || activeISA.xexts[desc.xext])
+}
+
+// InstrTable entry of a mnemonic the current ISA allows
+func lookup_instr(mnemonic string) (InstrDesc, error) {
+	name, _ := atomic_base(mnemonic) //lr.w.aq is lr.w
+	itype, ok := InstrTable[name]
+	if !ok {
+		return itype, fmt.Errorf("unknown instruction %q", mnemonic)
+	}
+	if !instr_enabled(itype) {
+		missing := ext_names(itype.ext)
+		switch {
+		case itype.ext == ExtNone || activeISA.exts&itype.ext != 0:
+			missing = ext_names(itype.needs &^ activeISA.exts)
+		case itype.needs&^activeISA.exts != 0:
+			missing += " and " + ext_names(itype.needs&^activeISA.exts)
+		}
+		if missing == "" {
+			missing = itype.xext
+		}
+		return itype, fmt.Errorf("%s needs extension %s, which %s does not include", mnemonic, missing, activeISA.src)
+	}
+	return itype, nil
+}
+
+// .attribute value of one tag: odd tags hold a string, even ones a number
+type attribute struct {
+	num uint64
+	str string
+}
+
+// attributes given with .attribute, written to .riscv.attributes. Tag_RISCV_arch always comes from activeISA
+var attributes = make(map[uint64]attribute)
+
+const tagRISCVArch = 5
+
+var attributeTags = map[string]uint64{
+	"stack_align":        4,
+	"arch":               tagRISCVArch,
+	"unaligned_access":   6,
+	"priv_spec":          8,
+	"priv_spec_minor":    10,
+	"priv_spec_revision": 12,
+	"atomic_abi":         14,
+	"x3_reg_usage":       16,
+}
+
+// .attribute tag, value where tag is a name (arch, Tag_RISCV_arch) or a number. .attribute arch switches
+// the ISA the whole file is checked against
+func set_attribute(op_split []string, curr_idx int) error {
+	if len(op_split) != 2 {
+		return fmt.Errorf(".attribute takes a tag and a value")
+	}
+	name, val, ok := strings.Cut(op_split[1], ",")
+	name, val = strings.TrimSpace(name), strings.TrimSpace(val)
+	if !ok || name == "" || val == "" {
+		return fmt.Errorf(".attribute takes a tag and a value")
+	}
+	tag, known := attributeTags[strings.TrimPrefix(strings.ToLower(name), "tag_riscv_")]
+	if !known {
+		num, err := strconv.ParseUint(name, 0, 64)
+		if err != nil || num < 4 { //the lower tags are generic ones about the file itself
+			return fmt.Errorf(".attribute: unknown tag %s", name)
+		}
+		tag = num
+	}
+	if tag%2 == 1 {
+		str, err := strconv.Unquote(val)
+		if err != nil || val[0] != '"' {
+			return fmt.Errorf(".attribute %s takes a quoted string", name)
+		}
+		if tag == tagRISCVArch {
+			a, err := check_isa(str)
+			if err != nil {
+				return fmt.Errorf(".attribute arch: %w", err)
+			}
+			activeISA = a
+			return nil
+		}
+		attributes[tag] = attribute{str: str}
+		return nil
+	}
+	num, err := eval_expr(val, curr_idx)
+	if err != nil {
+		return fmt.Errorf(".attribute %s: %w", name, err)
+	}
+	if num < 0 {
+		return fmt.Errorf(".attribute %s: %d is negative", name, num)
+	}
+	attributes[tag] = attribute{num: uint64(num)}
+	return nil
+}
@@ -0,0 +1,160 @@
+package assembler
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// lets a test use every built-in instruction, restoring -march afterwards
+func enable_all_exts(t testing.TB) {
+	t.Helper()
+	saved := targetISA
+	targetISA.exts = ^ExtNone
+	activeISA = targetISA
+	t.Cleanup(func() { targetISA, activeISA = saved, saved })
+}
+
+func TestParseISA(t *testing.T) {
+	for _, tc := range []struct {
+		march string
+		want  string
+	}{
+		{"rv32i", "rv32i2p1"},
+		{"rv32e", "rv32e2p0"},
+		{"RV32IMAC", "rv32i2p1_m2p0_a2p1_c2p0"},
+		{"rv32imac_zicsr_zifencei", "rv32i2p1_m2p0_a2p1_c2p0_zicsr2p0_zifencei2p0"},
+		{"rv32i2p1_m2p0_zicsr2p0", "rv32i2p1_m2p0_zicsr2p0"},
+		{"rv32imzicsr", "rv32i2p1_m2p0_zicsr2p0"},
+		{"rv32id", "rv32i2p1_f2p2_d2p2_zicsr2p0"},
+		{"rv32ib", "rv32i2p1_b1p0_zba1p0_zbb1p0_zbs1p0"},
+		{"rv32i_zbs_zba", "rv32i2p1_zba1p0_zbs1p0"},
+		{"rv32i_zkn_zicond", "rv32i2p1_zicond1p0_zbkb1p0_zbkc1p0_zbkx1p0_zkn1p0_zknd1p0_zkne1p0_zknh1p0"},
+		{"rv64gc", "rv64i2p1_m2p0_a2p1_f2p2_d2p2_c2p0_zicsr2p0_zifencei2p0"},
+	} {
+		a, err := parse_isa(tc.march)
+		if err != nil {
+			t.Errorf("%s: %v", tc.march, err)
+			continue
+		}
+		if got := a.String(); got != tc.want {
+			t.Errorf("%s = %s, want %s", tc.march, got, tc.want)
+		}
+	}
+	for _, march := range []string{"", "rv", "rv16i", "rv32", "rv32x", "rv32iam", "rv32imm", "rv32i_zfoo", "rv32i__m", "rv32i_", "rv32i_xnotloaded", "rv32gm"} {
+		if _, err := parse_isa(march); err == nil {
+			t.Errorf("%q was accepted", march)
+		}
+	}
+	for _, march := range []string{"rv64gc", "rv64i"} {
+		if err := SetArch(march); err == nil || !strings.Contains(err.Error(), "only RV32") {
+			t.Errorf("-march=%s: %v", march, err)
+		}
+	}
+	t.Cleanup(func() { SetArch("rv32i") })
+	for _, march := range []string{"rv32g", "rv32gc", "rv32imafd_zicsr", "rv32imac_zicsr_zifencei"} {
+		if err := SetArch(march); err != nil {
+			t.Errorf("-march=%s: %v", march, err)
+		}
+	}
+}
+
+func TestExtensionGating(t *testing.T) {
+	if _, err := Assemble([]string{"mul a0, a1, a2"}); err == nil || !strings.Contains(err.Error(), "extension m") {
+		t.Errorf("mul with -march=%s: %v", Arch, err)
+	}
+	if _, err := Assemble([]string{`.attribute arch, "rv32im"`, "mul a0, a1, a2"}); err != nil {
+		t.Error(err)
+	}
+	if _, err := Assemble([]string{"mul a0, a1, a2"}); err == nil {
+		t.Error(".attribute arch outlived its file")
+	}
+	if _, err := Assemble([]string{`.attribute arch, "rv32i_zba_zbs"`, "clz a0, a1"}); err == nil || !strings.Contains(err.Error(), "extension zbb") {
+		t.Errorf("clz without zbb: %v", err)
+	}
+	if _, err := Assemble([]string{"rol a0, a1, a2"}); err == nil || !strings.Contains(err.Error(), "zbb or zbkb") {
+		t.Errorf("rol without zbb/zbkb: %v", err)
+	}
+	for _, line := range []string{"aes32esi a0, a1, a2, 4", "aes32esi a0, a1, a2", "sm4ed a0, a1, a2, -1"} {
+		if _, err := Assemble([]string{`.attribute arch, "rv32i_zkne_zksed"`, line}); err == nil {
+			t.Errorf("%q was accepted", line)
+		}
+	}
+	if _, err := Assemble([]string{`.attribute arch, "rv32if_zfhmin"`, "fcvt.d.h fa0, fa1"}); err == nil || !strings.Contains(err.Error(), "extension d") {
+		t.Errorf("fcvt.d.h without d: %v", err)
+	}
+	if _, err := Assemble([]string{`.attribute arch, "rv32i_zfhmin"`, "fadd.h fa0, fa1, fa2"}); err == nil || !strings.Contains(err.Error(), "extension zfh") {
+		t.Errorf("fadd.h without zfh: %v", err)
+	}
+	if _, err := Assemble([]string{`.attribute arch, "rv32i_zicsr"`, "csrrw a0, hgatp, a1"}); err == nil || !strings.Contains(err.Error(), "extension h") {
+		t.Errorf("hgatp without h: %v", err)
+	}
+	if _, err := Assemble([]string{`.attribute arch, "rv32i_zicsr"`, "csrr a0, vsatp"}); err == nil || !strings.Contains(err.Error(), "extension h") {
+		t.Errorf("csrr of vsatp without h: %v", err)
+	}
+	if _, err := Assemble([]string{"csrr a0, mstatus"}); err == nil || !strings.Contains(err.Error(), "extension zicsr") {
+		t.Errorf("csrr without zicsr: %v", err)
+	}
+	for _, line := range []string{"csrrw a0, 0x1000, a1", "csrrwi a0, mstatus, 32", "csrrw a0, nocsr, a1", "hlv.w a0, 4(a1)",
+		"csrr a0", "csrr a0, mstatus, a1", "csrw mstatus", "csrsi mstatus, 32", "csrc a0, mstatus, a1"} {
+		if _, err := Assemble([]string{`.attribute arch, "rv32ih"`, line}); err == nil {
+			t.Errorf("%q was accepted", line)
+		}
+	}
+	for line, ext := range map[string]string{"lr.w.aq a0, (a1)": "extension a", "flw fa0, 0(a0)": "extension f", "fld fa0, 0(a0)": "extension d"} {
+		if _, err := Assemble([]string{line}); err == nil || !strings.Contains(err.Error(), ext) {
+			t.Errorf("%q with -march=%s: %v", line, Arch, err)
+		}
+	}
+	for _, line := range []string{"amoadd.w a0, a1, 4(a2)", "lr.w a0, a1, (a2)", "sc.w a0, (a2)", "lr.w.rlaq a0, (a1)", "add.aq a0, a1, a2", "fadd.d fa0, fa1, fa2, rtx"} {
+		if _, err := Assemble([]string{`.attribute arch, "rv32g"`, line}); err == nil {
+			t.Errorf("%q was accepted", line)
+		}
+	}
+	if _, err := Assemble([]string{`.attribute arch, "rv64i"`}); err == nil {
+		t.Error(".attribute arch accepted rv64")
+	}
+}
+
+func TestRISCVAttributes(t *testing.T) {
+	if _, err := Assemble([]string{`.attribute arch, "rv32imc"`, ".attribute stack_align, 16", `.attribute 9, "x"`}); err != nil {
+		t.Fatal(err)
+	}
+	got := string(riscv_attributes(binary.LittleEndian))
+	arch := "rv32i2p1_m2p0_c2p0"
+	body := "\x04\x10" + "\x05" + arch + "\x00" + "\x09x\x00"
+	want := "A" + string(rune(4+6+5+len(body))) + "\x00\x00\x00" + "riscv\x00" + "\x01" + string(rune(5+len(body))) + "\x00\x00\x00" + body
+	if got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+	for _, line := range []string{".attribute arch", `.attribute 3, "x"`, ".attribute arch, rv32i", ".attribute foo, 1", `.attribute stack_align, "16"`} {
+		if _, err := Assemble([]string{line}); err == nil {
+			t.Errorf("%q was accepted", line)
+		}
+	}
+}
+
+func TestRV32E(t *testing.T) {
+	saved := targetISA
+	t.Cleanup(func() { targetISA, activeISA = saved, saved })
+	if err := SetArch("rv32e"); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"add a6, a0, a1", "add a0, x16, a1", "lw a0, 0(s2)", "sw t6, 0(sp)", "jal t3, 0"} {
+		if _, err := Assemble([]string{line}); err == nil || !strings.Contains(err.Error(), "x0..x15") {
+			t.Errorf("%q with rv32e: %v", line, err)
+		}
+	}
+	if _, err := Assemble([]string{"add a5, x15, s1", "lw ra, 0(sp)"}); err != nil {
+		t.Error(err)
+	}
+	if flags := elf_header_flags(); flags&efRISCVRVE == 0 {
+		t.Errorf("e_flags %#x without RVE", flags)
+	}
+	if _, err := Assemble([]string{`.attribute arch, "rv32i"`, "add a6, a0, a1"}); err != nil {
+		t.Error(err)
+	}
+	if flags := elf_header_flags(); flags&efRISCVRVE != 0 {
+		t.Errorf("e_flags %#x with RVE for rv32i", flags)
+	}
+}
@@ -8,16 +8,19 @@ import (
 	"io"
 	"log"
 	"os"
-	"regexp"
 	"strconv"
 	"strings"
 )
 
 var symbolTable = make(map[string]*Symbol) //symbol mapping
-var valueTable = make(map[string]ilen)     //for equ
+var valueTable = make(map[string]int64)    //for equ
 var sectionTable = make(map[string]*Section)
+var sectionOrder = make([]string, 0, 4)  //sections in the order they first appear
+var localLabels = make(map[string][]int) //numeric label -> line indices it is defined on
 var instr_addresses = make([]ilen, 0, 10)
-var quoted = regexp.MustCompile(`"([^"\\]*(\\.[^"\\]*)*)"`)
+var instr_sections = make([]*Section, 0, 10) //section each line was assembled into
+var instr_sizes = make([]ilen, 0, 10)        //bytes each line occupies
+var bin_image []byte                         //loaded image built by SecondPass
 
 func Print_Bin(filename string) {
 	file, err := os.Open(filename)
@@ -51,58 +54,141 @@ func Print_Info() {
 		fmt.Printf("  %s: %d\n", key, val)
 	}
 	for key, val := range sectionTable {
-		fmt.Printf("Section: %s (addr, sz) = (0x%X, %d bytes)\n", key, val.addr, val.sz)
+		fmt.Printf("Section: %s [%s] (addr, sz) = (0x%X, %d bytes)\n", key, val.flag_string(), val.addr, val.sz)
 		for _, val := range symbolTable {
-			if val.section.name == key {
-				fmt.Printf("  (%s) offset from section: 0x%X\n", val.name, val.offset)
+			if val.section != nil && val.section.name == key {
+				fmt.Printf("  (%s) offset from section: 0x%X [%s]\n", val.name, val.offset, val.attr_string())
 			}
 		}
 	}
 }
 
-// minimal cleaning and just returns slice of all lines that aren't empty or start with comments
+// minimal cleaning and just returns slice of all lines that aren't empty or start with comments.
+// .rept/.irp/.irpc blocks are expanded here so FirstPass sees every repeated line
 func ParseFile(filename string) []string {
 	fmt.Printf("Parsing Assembly File %s...\n", filename)
 	data, err := os.Open(filename)
 	if err != nil {
-		data.Close()
 		log.Fatal(err)
 	}
+	defer data.Close()
+	lines, err := parse_lines(data)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return lines
+}
+
+// ParseSource is ParseFile for source held in memory
+func ParseSource(src string) ([]string, error) {
+	return parse_lines(strings.NewReader(src))
+}
+
+func parse_lines(data io.Reader) ([]string, error) {
 	scanner := bufio.NewScanner(data)
 	lines := make([]string, 0)
-	var spaceCollapse = regexp.MustCompile(`\s+`)
 	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		line = spaceCollapse.ReplaceAllString(line, " ")
-		if line == "" || (len(line) >= 1 && line[0:1] == "#") {
+		line := clean_line(scanner.Text())
+		if line == "" {
 			continue
 		}
-		// Remove comments, if any
-		if idx := strings.Index(line, "#"); idx != -1 {
-			line = strings.TrimSpace(line[:idx])
-		}
 		lines = append(lines, line)
 	}
 	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return expand_repetitions(lines)
+}
+
+// drops the comment and collapses whitespace runs to one space, leaving "..." and '...' literals as written
+func clean_line(line string) string {
+	var sb strings.Builder
+	var quote byte
+	space := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if quote == 0 && strings.IndexByte(" \t\r\f\v", c) >= 0 {
+			space = true
+			continue
+		}
+		if quote == 0 && c == '#' {
+			break
+		}
+		if space && sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		space = false
+		sb.WriteByte(c)
+		switch {
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote != 0 && c == '\\' && i+1 < len(line): //an escaped quote doesn't end the literal
+			i++
+			sb.WriteByte(line[i])
+		case c == quote:
+			quote = 0
+		}
+	}
+	return sb.String()
+}
+
+// Lays out the program, then grows any branch/jump that cannot reach its target, relaxes gp sequences and lays it
+// out again until nothing changes. Branches only grow and a sequence that is undone is never shrunk again, so
+// this always settles. Returns binary file size
+func FirstPass(instructions []string) ilen {
+	bin_sz, err := first_pass(instructions)
+	if err != nil {
 		log.Fatal(err)
 	}
-	return lines
+	return bin_sz
+}
+
+func first_pass(instructions []string) (ilen, error) {
+	valueTable = make(map[string]int64) //nothing carries over from an earlier assembly
+	predefine_syscalls()
+	relaxLevels = make(map[int]int)
+	relaxPinned = make(map[int]bool)
+	gpRelaxed = make(map[string]bool)
+	gpPinned = make(map[string]bool)
+	for {
+		bin_sz, err := layout_pass(instructions)
+		if err != nil {
+			return 0, err
+		}
+		settle_assignments(instructions) //gp may be an assignment
+		grew := grow_far_branches(instructions)
+		shrunk := Relax && shrink_gp_sequences(instructions)
+		if !grew && !shrunk {
+			return bin_sz, nil
+		}
+		fmt.Printf("\ninstruction sizes changed, redoing layout\n\n")
+	}
 }
 
 // Loop through every directve/instruction. Record which section each one is in and the offset address it is in each respective section. Returns binary file size
-func FirstPass(instructions []string) ilen {
-	var section = ""
-	var addr ilen = 0x0
+func layout_pass(instructions []string) (ilen, error) {
+	reset_tables()
+	var section = ".text" //default is .text
+	enter_section(section)
 
 	for i := 0; i < len(instructions); i++ {
+		sec := sectionTable[section]
+		addr := sec.sz //every section keeps its own location counter
 		instr_addresses = append(instr_addresses, addr)
-		new_addr, err := FirstPassLine(instructions[i], ilen(addr), &section)
+		instr_sections = append(instr_sections, sec)
+		new_addr, err := FirstPassLine(i, instructions[i], addr, &section)
 		if err != nil {
-			log.Fatal(err)
+			return 0, fmt.Errorf("%q: %s", instructions[i], err)
+		}
+		if new_addr < addr || new_addr > maxSectionSize {
+			return 0, fmt.Errorf("%q: %s would grow past %d bytes", instructions[i], sec.name, maxSectionSize)
+		}
+		if sectionTable[section] == sec {
+			sec.sz = new_addr
 		}
-		addr = new_addr
+		instr_sizes = append(instr_sizes, sec.sz-addr)
 	}
-	return addr
+	return layout_sections(), nil
 }
 
 // loop through every instruction and plug in addresses of .words, .dword, .equ etc into instructions. Fill in actual value into memory for .words and such
@@ -114,10 +200,9 @@ func SecondPass(instructions []string, bin_sz ilen) {
 	}
 	defer bin_file.Close()
 
-	var section = ""
-	var byte_arr = make([]byte, bin_sz)
-	for i := 0; i < len(instructions); i++ {
-		BinGenerationLine(i, byte_arr, instructions[i], &section)
+	byte_arr, err := generate_image(instructions, bin_sz)
+	if err != nil {
+		log.Fatal(err)
 	}
 	err = binary.Write(bin_file, binary.LittleEndian, byte_arr)
 	if err != nil {
@@ -125,131 +210,248 @@ func SecondPass(instructions []string, bin_sz ilen) {
 	}
 }
 
+// Assemble runs both passes over lines from ParseFile/ParseSource and returns the loaded image without writing
+// any files. WriteELF and Disassemble can be used on the result afterwards. Problems in the source come back as
+// an error naming the line instead of stopping the program
+func Assemble(instructions []string) ([]byte, error) {
+	bin_sz, err := first_pass(instructions)
+	if err != nil {
+		return nil, err
+	}
+	return generate_image(instructions, bin_sz)
+}
+
+// encodes every line into a fresh image, which is also kept for WriteELF
+func generate_image(instructions []string, bin_sz ilen) ([]byte, error) {
+	var section = ".text"
+	var byte_arr = make([]byte, bin_sz)
+	settle_assignments(instructions)
+	for i := 0; i < len(instructions); i++ {
+		buf := byte_arr
+		if sec := instr_sections[i]; sec.flags&SecAlloc == 0 {
+			buf = sec.data //not loaded, so kept out of the image
+		}
+		_, err := BinGenerationLine(i, buf, instructions[i], &section)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %s", instructions[i], err)
+		}
+	}
+	bin_image = byte_arr
+	return byte_arr, nil
+}
+
 // cleans every line of code getting rid of comments and ensuring everything is in the correct format. Returns (instruction, addr, error)
-func FirstPassLine(line string, curr_addr ilen, section *string) (ilen, error) {
+func FirstPassLine(curr_idx int, line string, curr_addr ilen, section *string) (ilen, error) {
 	var next_addr = curr_addr
+	if line == "" {
+		return next_addr, nil //ParseFile drops these, other callers might not
+	}
 	var op_split = strings.SplitN(line, " ", 2)
+	//sym = expr
+	if key, val, ok := parse_assignment(line); ok {
+		fmt.Printf("(0x%X) %s\n", curr_addr, line)
+		return next_addr, set_symbol_value(key, val, curr_idx, false)
+	}
 	//line is a directive
 	if line[0] == '.' {
 		switch op_split[0] {
 		case ".org": //set location counter to absolute offset line[1]
+			if len(op_split) < 2 {
+				return 0, errors.New(".org needs an address")
+			}
 			val, err := strconv.ParseUint(strings.TrimSpace(op_split[1]), 0, 32)
 			if err != nil {
-				log.Fatal("immediate given is not decimal, hex, nor binary")
+				return 0, errors.New("immediate given is not decimal, hex, nor binary")
 			}
 			sz := align_size(reg(val), 4)
+			if ilen(sz) < curr_addr {
+				return 0, fmt.Errorf(".org 0x%X moves %s backwards from 0x%X", sz, *section, curr_addr)
+			}
 			next_addr = ilen(sz)
 
-		case ".align": //align to specified boundary
-			if *section == "" {
-				*section = ".text"
-				sectionTable[*section] = &Section{name: ".text", addr: curr_addr, sz: 0}
-			}
-			alignment, err := strconv.ParseUint(strings.TrimSpace(op_split[1]), 0, 32)
+		case ".align", ".p2align", ".balign": //align to specified boundary
+			byte_align, _, max_skip, err := align_operands(op_split, curr_idx)
 			if err != nil {
-				log.Fatal("immediate given is not decimal, hex, nor binary")
+				return 0, err
 			}
-			byte_align := alignment / uint64(BYTE_SZ)
-			remainder := ilen(byte_align) - (curr_addr % ilen(byte_align))
-			next_addr += remainder //aligns address
 			sec := sectionTable[*section]
-			sec.sz += remainder
+			if byte_align > sec.align {
+				sec.align = byte_align //layout keeps the section start aligned too
+			}
+			next_addr += align_padding(curr_addr, byte_align, max_skip) //aligns address
 
-		case ".globl":
-			if *section == "" {
-				*section = ".text"
-				sectionTable[*section] = &Section{name: ".text", addr: curr_addr, sz: 0}
+		case ".globl", ".global", ".local":
+			names, err := symbol_names(op_split)
+			if err != nil {
+				return 0, err
+			}
+			for _, name := range names { //stays undefined (SHN_UNDEF) unless a label defines it
+				symbol_attrs(name).global = op_split[0] != ".local"
 			}
 
-			secBase := sectionTable[*section].addr
-			symbolTable[op_split[1]] = &Symbol{section: sectionTable[*section], name: op_split[1], offset: curr_addr - secBase, global: true}
+		case ".weak", ".hidden", ".protected", ".internal":
+			err := set_symbol_binding(op_split)
+			if err != nil {
+				return 0, err
+			}
+
+		case ".type":
+			err := set_symbol_type(op_split)
+			if err != nil {
+				return 0, err
+			}
 
-		case ".local":
-			if *section == "" {
-				*section = ".text"
-				sectionTable[*section] = &Section{name: ".text", addr: curr_addr, sz: 0}
+		case ".size":
+			err := set_symbol_size(op_split, curr_idx, false)
+			if err != nil {
+				return 0, err
 			}
-			secBase := sectionTable[*section].addr
-			symbolTable[op_split[1]] = &Symbol{section: sectionTable[*section], name: op_split[1], offset: curr_addr - secBase, global: false}
 
-		case ".equ": //only for instruction length sized stuff
+		case ".equ", ".set": //only for instruction length sized stuff
 			//don't need to populate .equ since it doesn't matter what section or address it is at
-			args := strings.SplitN(op_split[1], ",", 2)
-			fmt.Println(args)
-			key := strings.TrimSpace(args[0])
-			val, err := strconv.ParseInt(strings.TrimSpace(args[1]), 0, 64)
+			key, val, err := assignment_operands(op_split)
+			if err != nil {
+				return 0, err
+			}
+			err = set_symbol_value(key, val, curr_idx, false)
+			if err != nil {
+				return 0, err
+			}
+
+		case ".section", ".pushsection":
+			err := set_section(section, op_split)
 			if err != nil {
-				log.Fatalf("equ value is not valid")
+				return 0, err
 			}
-			valueTable[key] = ilen(val)
 
-		case ".section":
-			*section = op_split[1]
-			sectionTable[op_split[1]] = &Section{name: op_split[1], addr: curr_addr, sz: 0}
-			//next_addr += ILEN_BYTES
+		case ".popsection":
+			err := pop_section(section)
+			if err != nil {
+				return 0, err
+			}
+
+		case ".previous":
+			err := previous_section(section)
+			if err != nil {
+				return 0, err
+			}
 
 		case ".text", ".data", ".bss", ".rodata":
-			*section = op_split[0]
-			sectionTable[op_split[0]] = &Section{name: op_split[0], addr: curr_addr, sz: 0}
-			//next_addr += ILEN_BYTES
+			switch_section(section, op_split[0])
 
-		case ".asciz":
-			asciz, err := strconv.Unquote(quoted.FindString(line))
+		case ".option":
+			err := set_option(op_split)
 			if err != nil {
-				log.Fatalf("unquoting asciz failed")
+				return 0, err
 			}
-			str_sz := ilen(len(asciz) + 1) //in bytes including /0
-			sec := sectionTable[*section]
-			sec.sz += align_addr(ilen(str_sz))
-			next_addr += align_addr(ilen(str_sz)) //increases address by aligned amount
+
+		case ".attribute":
+			err := set_attribute(op_split, curr_idx)
+			if err != nil {
+				return 0, err
+			}
+
+		case ".insn":
+			if sectionTable[*section].flags&SecExec == 0 {
+				return 0, errors.New("instructions must be in an executable section")
+			}
+			size, err := insn_size(op_split, curr_idx)
+			if err != nil {
+				return 0, err
+			}
+			next_addr += size
+
+		case ".asciz", ".string", ".ascii":
+			if sectionTable[*section].nobits {
+				return 0, fmt.Errorf("%s cannot store data in NOBITS section %s", op_split[0], *section)
+			}
+			strs, err := parse_strings(op_split)
+			if err != nil {
+				return 0, err
+			}
+			str_sz := ilen(0)
+			for _, str := range strs {
+				str_sz += ilen(len(str))
+				if op_split[0] != ".ascii" {
+					str_sz++ //in bytes including /0
+				}
+			}
+			next_addr += str_sz
 
 		case ".zero":
-			zero_sz, err := strconv.Atoi(strings.TrimSpace(op_split[1]))
+			if len(op_split) < 2 {
+				return 0, errors.New(".zero needs a size")
+			}
+			zero_sz, err := strconv.ParseUint(strings.TrimSpace(op_split[1]), 0, 32)
 			if err != nil {
-				log.Fatalf("atoi .zero failed")
+				return 0, fmt.Errorf(".zero size %q is not a number", op_split[1])
 			}
-			sec := sectionTable[*section]
-			sec.sz += ilen(zero_sz)
 			next_addr += align_addr(ilen(zero_sz))
 
-		case ".half": // 16 bit words
-			words := strings.Split(line[5:], ",")
-			word_sz := ilen(len(words)) * 2
-			sec := sectionTable[*section]
-			sec.sz += word_sz
-			next_addr += word_sz
+		case ".space", ".skip": // size[, fill]
+			size, fill, err := space_operands(op_split, curr_idx)
+			if err != nil {
+				return 0, err
+			}
+			if fill != 0 && sectionTable[*section].nobits {
+				return 0, fmt.Errorf("%s fill 0x%X in NOBITS section %s", op_split[0], fill, *section)
+			}
+			next_addr += size
 
-		case ".word": // 32 bit words
-			words := strings.Split(line[5:], ",")
-			word_sz := ilen(len(words)) * 4
-			sec := sectionTable[*section]
-			sec.sz += word_sz
-			next_addr += align_addr(word_sz)
+		case ".comm", ".lcomm": // sym, size[, align]
+			err := declare_common(op_split, curr_idx)
+			if err != nil {
+				return 0, err
+			}
 
-		case ".dword": // 64 bit words
-			words := strings.Split(line[6:], ",")
-			word_sz := ilen(len(words)) * 8
-			sec := sectionTable[*section]
-			sec.sz += word_sz
-			next_addr += align_addr(word_sz)
+		case ".byte", ".half", ".short", ".2byte", ".word", ".long", ".4byte", ".dword", ".quad", ".8byte", ".float16", ".bfloat16", ".float", ".double":
+			if sectionTable[*section].nobits {
+				return 0, fmt.Errorf("%s cannot store data in NOBITS section %s", op_split[0], *section)
+			}
+			words, err := data_operands(op_split)
+			if err != nil {
+				return 0, err
+			}
+			word_sz := ilen(len(words) * dataWidths[op_split[0]])
+			next_addr += word_sz
+
+		case ".uleb128", ".sleb128": // size depends on the value so it has to be known now
+			if sectionTable[*section].nobits {
+				return 0, fmt.Errorf("%s cannot store data in NOBITS section %s", op_split[0], *section)
+			}
+			words, err := data_operands(op_split)
+			if err != nil {
+				return 0, err
+			}
+			leb_sz := ilen(0)
+			for _, word := range words {
+				val, err := eval_expr(word, curr_idx)
+				if err != nil {
+					return 0, fmt.Errorf("%s operand must be known on first pass: %w", op_split[0], err)
+				}
+				leb_sz += ilen(len(encode_leb128(val, op_split[0] == ".sleb128")))
+			}
+			next_addr += leb_sz
 
 		default:
-			log.Fatal("unknown assembler directive!")
+			return 0, fmt.Errorf("unknown assembler directive %s", op_split[0])
 		}
 		fmt.Printf("(0x%X) %s\n", curr_addr, line)
 		return next_addr, nil
 	} else {
-		//default is .text
-		if *section == "" {
-			*section = ".text"
-			sectionTable[*section] = &Section{name: ".text", addr: curr_addr, sz: 0}
-		}
 		sec := sectionTable[*section]
 		//is label
 		if strings.HasSuffix(op_split[0], ":") {
 			op_split[0] = strings.TrimSuffix(op_split[0], ":")
+			if is_local_label(op_split[0]) {
+				//numeric labels can be redefined, so only remember where each definition is
+				localLabels[op_split[0]] = append(localLabels[op_split[0]], curr_idx)
+				fmt.Printf("(0x%X) %s\n", curr_addr, line)
+				return next_addr, nil
+			}
 			symbol, ok := symbolTable[op_split[0]]
 			if ok {
+				symbol.section = sec
 				symbol.offset = curr_addr - sec.addr
 			} else {
 				symbolTable[op_split[0]] = &Symbol{section: sectionTable[*section], name: op_split[0], offset: curr_addr - sec.addr, global: false}
@@ -257,12 +459,14 @@ func FirstPassLine(line string, curr_addr ilen, section *string) (ilen, error) {
 
 		} else {
 			//is instruction
-			if *section != ".text" {
+			if sec.flags&SecExec == 0 {
 				fmt.Printf("section is %s\n", *section)
-				return 0, errors.New("instructions must be in text")
+				return 0, errors.New("instructions must be in an executable section")
 			}
-			next_addr += ILEN_BYTES
-			sec.sz += ILEN_BYTES
+			if !optionRelax {
+				norelaxLines[curr_idx] = true
+			}
+			next_addr += instr_size(curr_idx, op_split)
 		}
 		fmt.Printf("(0x%X) %s\n", curr_addr, line)
 		return next_addr, nil
@@ -270,385 +474,378 @@ func FirstPassLine(line string, curr_addr ilen, section *string) (ilen, error) {
 }
 
 func BinGenerationLine(curr_idx int, bin_arr []byte, line string, section *string) (ilen, error) {
-	//default is .text
-	if *section == "" {
-		*section = ".text"
-		sectionTable[*section] = &Section{name: ".text", addr: instr_addresses[curr_idx], sz: 0}
-	}
-
+	*section = instr_sections[curr_idx].name //FirstPass already followed every section switch
 	var next_addr = instr_addresses[curr_idx]
+	if line == "" {
+		return next_addr, nil
+	}
 	var op_split = strings.SplitN(line, " ", 2)
+	//reassignments are replayed in order so each line sees the value current at that point
+	if key, val, ok := parse_assignment(line); ok {
+		return next_addr, set_symbol_value(key, val, curr_idx, true)
+	}
 
 	//line is a directive
 	if line[0] == '.' {
 		switch op_split[0] {
 		case ".org": //set location counter to absolute offset line[1]
-			//ignore if it is last instruction
-			if len(instr_addresses) == curr_idx+1 {
+			//NOBITS sections have no file bytes to pad
+			if sectionTable[*section].nobits {
 				break
 			}
-			pad_size := instr_addresses[curr_idx+1] - instr_addresses[curr_idx]
-			targAddr := instr_addresses[curr_idx] + ilen(pad_size)
-			for i := instr_addresses[curr_idx]; i < targAddr; i += 4 {
-				if *section == ".text" {
-					bin_arr[i] = 0x13 // NOP instruction
-				} else {
-					bin_arr[i] = 0x00
-				}
-				bin_arr[i+1] = 0x00
-				bin_arr[i+2] = 0x00
-				bin_arr[i+3] = 0x00
+			fill_padding(bin_arr, instr_addresses[curr_idx], instr_sizes[curr_idx], -1, is_code_section(*section))
+		case ".align", ".p2align", ".balign": //align to specified boundary
+			if sectionTable[*section].nobits {
+				break
+			}
+			_, fill, _, err := align_operands(op_split, curr_idx)
+			if err != nil {
+				return next_addr, err
+			}
+			fill_padding(bin_arr, instr_addresses[curr_idx], instr_sizes[curr_idx], fill, is_code_section(*section))
+		case ".globl", ".global", ".local", ".weak", ".hidden", ".protected", ".internal", ".type", ".zero", ".comm", ".lcomm":
+			break
+		case ".equ", ".set":
+			key, val, err := assignment_operands(op_split)
+			if err != nil {
+				return next_addr, err
+			}
+			err = set_symbol_value(key, val, curr_idx, true)
+			if err != nil {
+				return next_addr, err
 			}
-		case ".align": //align to specified boundary
-			//ignore if it is last instruction
-			if len(instr_addresses) == curr_idx+1 {
+		case ".size":
+			err := set_symbol_size(op_split, curr_idx, true)
+			if err != nil {
+				return next_addr, err
+			}
+		case ".space", ".skip":
+			if sectionTable[*section].nobits {
 				break
 			}
-			pad_size := instr_addresses[curr_idx+1] - instr_addresses[curr_idx]
-			targAddr := instr_addresses[curr_idx] + ilen(pad_size)
-			for i := instr_addresses[curr_idx]; i < targAddr; i += 1 {
-				if *section == ".text" {
-					bin_arr[i] = 0x13 // NOP instruction
-				} else {
-					bin_arr[i] = 0x00
-				}
+			size, fill, err := space_operands(op_split, curr_idx)
+			if err != nil {
+				return next_addr, err
 			}
-		case ".globl", ".local", ".equ", ".zero":
+			for i := ilen(0); i < size; i++ {
+				bin_arr[instr_addresses[curr_idx]+i] = fill
+			}
+		case ".section", ".pushsection", ".popsection", ".previous", ".text", ".data", ".bss", ".rodata", ".option", ".attribute":
 			break
-		case ".section":
-			*section = op_split[1]
-		case ".text", ".data", ".bss", ".rodata":
-			*section = op_split[0]
-		case ".asciz":
-			asciz, err := strconv.Unquote(quoted.FindString(line))
+		case ".insn":
+			err := emit_insn(curr_idx, bin_arr, op_split)
 			if err != nil {
-				log.Fatalf("unquoting asciz failed")
+				return next_addr, err
 			}
-			i := instr_addresses[curr_idx]
-			for ; i < instr_addresses[curr_idx]+ilen(len(asciz)); i++ {
-				bin_arr[i] = asciz[i-instr_addresses[curr_idx]]
+		case ".asciz", ".string", ".ascii":
+			strs, err := parse_strings(op_split)
+			if err != nil {
+				return next_addr, err
 			}
-			bin_arr[i] = 0 //automatic terminator
-		case ".half": // 16 bit words
-			words := strings.Split(op_split[1], ",")
 			i := instr_addresses[curr_idx]
-			cnt := 0
-			for ; i < instr_addresses[curr_idx]+ilen(2*len(words)); i += 2 {
-				num, err := strconv.ParseUint(strings.TrimSpace(words[cnt]), 0, 16)
-				if err != nil {
-					fmt.Println(err)
-					log.Fatalf("converted half word to integer failure") //autmatically checks bounds
+			for _, str := range strs {
+				i += ilen(copy(bin_arr[i:], str))
+				if op_split[0] != ".ascii" {
+					bin_arr[i] = 0 //automatic terminator
+					i++
 				}
-				bin_arr[i] = byte((num & 0xFF00) >> 8)
-				bin_arr[i+1] = byte(num & 0xFF)
-				//fmt.Printf("num = 0x%02X \t 0x%02X\t 0x%02X \n", num, bin_arr[i], bin_arr[i+1])
-				cnt++
 			}
-
-		case ".word": // 32 bit words
-			words := strings.Split(op_split[1], ",")
+		case ".byte", ".half", ".short", ".2byte", ".word", ".long", ".4byte", ".dword", ".quad", ".8byte", ".float16", ".bfloat16", ".float", ".double":
+			words, err := data_operands(op_split)
+			if err != nil {
+				return next_addr, err
+			}
+			width := dataWidths[op_split[0]]
 			i := instr_addresses[curr_idx]
-			cnt := 0
-			for ; i < instr_addresses[curr_idx]+ilen(4*len(words)); i += 4 {
-				num, err := strconv.ParseUint(strings.TrimSpace(words[cnt]), 0, 32)
+			for _, word := range words {
+				num, err := parse_data_value(op_split[0], word, curr_idx)
 				if err != nil {
-					fmt.Println(err)
-					log.Fatalf("converted word to integer failure") //autmatically checks bounds
+					return next_addr, fmt.Errorf("%s %s: %w", op_split[0], word, err)
 				}
-				bin_arr[i] = byte((num & 0xFF000000) >> 24)
-				bin_arr[i+1] = byte((num & 0xFF0000) >> 16)
-				bin_arr[i+2] = byte((num & 0xFF00) >> 8)
-				bin_arr[i+3] = byte(num & 0xFF)
-				//fmt.Printf("num = 0x%02X \t 0x%02X\t 0x%02X \t 0x%02X\t 0x%02X \n", num, bin_arr[i], bin_arr[i+1], bin_arr[i+2], bin_arr[i+3])
-				cnt++
+				put_bytes(bin_arr[i:], num, width)
+				i += ilen(width)
+			}
+		case ".uleb128", ".sleb128":
+			words, err := data_operands(op_split)
+			if err != nil {
+				return next_addr, err
 			}
-
-		case ".dword": // 64 bit words
-			words := strings.Split(op_split[1], ",")
 			i := instr_addresses[curr_idx]
-			cnt := 0
-			for ; i < instr_addresses[curr_idx]+ilen(8*len(words)); i += 8 {
-				num, err := strconv.ParseUint(strings.TrimSpace(words[cnt]), 0, 64)
+			for _, word := range words {
+				val, err := eval_expr(word, curr_idx)
 				if err != nil {
-					fmt.Println(err)
-					log.Fatalf("converted word to integer failure") //autmatically checks bounds
+					return next_addr, fmt.Errorf("%s %s: %w", op_split[0], word, err)
 				}
-				bin_arr[i] = byte((num & 0xFF00000000000000) >> 56)
-				bin_arr[i+1] = byte((num & 0xFF000000000000) >> 48)
-				bin_arr[i+2] = byte((num & 0xFF0000000000) >> 40)
-				bin_arr[i+3] = byte(num & 0xFF00000000 >> 32)
-				bin_arr[i+4] = byte((num & 0xFF000000) >> 24)
-				bin_arr[i+5] = byte((num & 0xFF0000) >> 16)
-				bin_arr[i+6] = byte((num & 0xFF00) >> 8)
-				bin_arr[i+7] = byte(num & 0xFF)
-				//fmt.Printf("num = 0x%02X \t 0x%02X\t 0x%02X \t 0x%02X\t 0x%02X \n", num, bin_arr[i], bin_arr[i+1], bin_arr[i+2], bin_arr[i+3])
-				cnt++
+				i += ilen(copy(bin_arr[i:], encode_leb128(val, op_split[0] == ".sleb128")))
 			}
 		default:
-			log.Fatal("unknown assembler directive!")
+			return next_addr, fmt.Errorf("unknown assembler directive %s", op_split[0])
 		}
 		fmt.Printf("(0x%X) %s\n", instr_addresses[curr_idx], line)
 		return next_addr, nil
 	}
-	// eventually add functionality to account for when the immediate is too big
 	if strings.HasSuffix(op_split[0], ":") {
 		return next_addr, nil
 	} // is a label
-	itype := InstrTable[op_split[0]]
+	op_split = expand_call(op_split)
+	op_split, err := expand_csr_alias(op_split)
+	if err != nil {
+		return next_addr, err
+	}
+	if op_split[0] == "la" || op_split[0] == "lla" {
+		return next_addr, emit_address_load(curr_idx, bin_arr, op_split)
+	}
+	itype, err := lookup_instr(op_split[0])
+	if err != nil {
+		return next_addr, err
+	}
+	operands := []string{}
+	if len(op_split) == 2 {
+		operands = split_operands(op_split[1])
+	}
+	return next_addr, encode_instruction(curr_idx, bin_arr, op_split[0], itype, operands)
+} // Instruction & labels
+
+// packs one instruction of any base format into the image. itype comes from InstrTable or from .insn
+func encode_instruction(curr_idx int, bin_arr []byte, mnemonic string, itype InstrDesc, operands []string) error {
 	instruction := ilen(0x0)
 	switch itype.fmt {
-	case R: // 3 operands: opcode, rd, funct3, rs1, rs2, funct7
-		operands := strings.SplitN(op_split[1], ", ", 3)
-		rd, inRd := regMap[operands[0]]
-		rs1, inRs1 := regMap[operands[1]]
-		rs2, inRs2 := regMap[operands[2]]
-		if !inRd || !inRs1 || !inRs2 {
-			log.Fatalln("invalid registers")
+	case R: // 3 operands: opcode, rd, funct3, rs1, rs2, funct7 (and a byte select for aes32*/sm4*)
+		count := 3
+		if itype.has_arg("bs") {
+			count = 4
 		}
-		instruction |= ilen(itype.Opcode)
+		if err := operand_count(mnemonic, operands, count); err != nil {
+			return err
+		}
+		rd, err := reg_operand(operands[0])
+		if err != nil {
+			return err
+		}
+		rs1, err := reg_operand(operands[1])
+		if err != nil {
+			return err
+		}
+		rs2, err := reg_operand(operands[2])
+		if err != nil {
+			return err
+		}
+		instruction = ilen(itype.match) //opcode, funct3 and funct7
 		instruction |= ilen(rd) << 7
-		instruction |= ilen(itype.funct3) << 12
 		instruction |= ilen(rs1) << 15
 		instruction |= ilen(rs2) << 20
+		if count == 4 {
+			bs, _, err := imm_operand(operands[3], curr_idx)
+			if err != nil {
+				return fmt.Errorf("%s: %w", mnemonic, err)
+			}
+			if err := check_imm(mnemonic, bs, bsRange); err != nil {
+				return err
+			}
+			instruction |= ilen(bs) << 30
+		}
 		populate_bin_instruction(instruction, instr_addresses[curr_idx], bin_arr)
 		fmt.Printf("R instr: %032b \n", instruction)
 	case I: // immediate / loads / jalr rd, rs1, imm  OR  lw rd, offset(rs1)
-		var operands = strings.SplitN(op_split[1], ", ", 3)
-		var rd, inRd = regMap[operands[0]]
-		var rs1 uint8
-		var inRs1 = true
-		var immediate ilen
+		var rd, rs1 uint8
+		var immediate int64
+		var err error
 
-		if len(operands) == 3 {
-			rs1, inRs1 = regMap[operands[1]]
-			if !inRd && !inRs1 {
-				log.Fatalln("invalid I registers")
+		switch {
+		case len(itype.args) == 0: //ecall/ebreak, everything is fixed
+			if err := operand_count(mnemonic, operands, 0); err != nil {
+				return err
 			}
-			val, err := strconv.Atoi(operands[2])
-			immediate = ilen(val)
+		case !itype.has_arg("imm12") && !itype.has_arg("shamtw"): //rd, rs1 with the rest fixed
+			if err := operand_count(mnemonic, operands, 2); err != nil {
+				return err
+			}
+			rd, err = reg_operand(operands[0])
 			if err != nil {
-				log.Fatalf("cannot convert val into immediate")
+				return err
 			}
-		} else {
-			open := strings.Index(operands[1], "(")
-			close := strings.Index(operands[1], ")")
-			if open < 0 || close < 0 || close <= open {
-				log.Fatalln("invalid format, expected imm(reg)")
+			rs1, err = reg_operand(operands[1])
+			if err != nil {
+				return err
 			}
-			offset := operands[1][:open]
-			addr := operands[1][open+1 : close]
-			rs1, inRs1 = regMap[addr]
-			if !inRd && !inRs1 {
-				log.Fatalln("invalid I registers")
+		case len(operands) == 3:
+			rd, err = reg_operand(operands[0])
+			if err != nil {
+				return err
 			}
-
-			//check for equ
-			val, ok := valueTable[offset]
-			if ok {
-				immediate = val
-			} else {
-				val, err := strconv.Atoi(offset)
-				immediate = ilen(val)
-				if err != nil {
-					log.Fatalf("cannot convert val into immediate")
-				}
+			rs1, err = reg_operand(operands[1])
+			if err != nil {
+				return err
 			}
+			immediate, _, err = imm_operand(operands[2], curr_idx)
+			if err != nil {
+				return fmt.Errorf("%s: %w", mnemonic, err)
+			}
+		case len(operands) == 2:
+			rd, err = reg_operand(operands[0])
+			if err != nil {
+				return err
+			}
+			immediate, rs1, err = mem_operand(operands[1], curr_idx)
+			if err != nil {
+				return fmt.Errorf("%s: %w", mnemonic, err)
+			}
+		default:
+			return fmt.Errorf("%s takes rd, rs1, imm or rd, imm(rs1)", mnemonic)
 		} //immediate is an address
-		if op_split[0] == "ssli" || op_split[0] == "srli" || op_split[0] == "srai" {
-			immediate &= 0x1F
-			if op_split[0] == "srai" {
-				immediate |= 0x20 << 5
+		if len(operands) > 0 { //not ecall/ebreak
+			if offset, ok := gp_relative(operands[len(operands)-1], curr_idx); ok {
+				rs1, immediate = regMap["gp"], offset
 			}
 		}
-		fmt.Printf("%07b %05b %03b %05b %012b \n", itype.Opcode, rd, itype.funct3, rs1, immediate)
-		instruction |= ilen(itype.Opcode)
-		instruction |= ilen(rd) << 7
-		instruction |= ilen(itype.funct3) << 12
-		instruction |= ilen(rs1) << 15       //000000001010 01100 000 01000 1100111
-		instruction |= ilen(immediate) << 20 //010001010110 01011 000 00110 0010011
+
+		if itype.has_arg("shamtw") { //imm[11:5] is fixed (0x20 for srai)
+			if err := check_imm(mnemonic, immediate, shamtRange); err != nil {
+				return err
+			}
+		} else if err := check_imm(mnemonic, immediate, iRange); err != nil {
+			return err
+		}
+		fmt.Printf("%07b %05b %03b %05b %012b \n", itype.Opcode, rd, itype.funct3, rs1, immediate&0xFFF)
+		instruction = pack_i(itype.Opcode, itype.funct3, rd, rs1, immediate) | ilen(itype.match)
 		populate_bin_instruction(instruction, instr_addresses[curr_idx], bin_arr)
 		fmt.Printf("I instr: %032b\n", instruction)
 	case S: // store: rs2, offset(rs1)
-		var operands = strings.SplitN(op_split[1], ", ", 3)
-		var first_imm ilen
-		var sec_imm ilen
-		var rs1, inRs1 = regMap[operands[0]]
-		var rs2, inRs2 = uint8(0), true
-
-		open := strings.Index(operands[1], "(")
-		close := strings.Index(operands[1], ")")
-		if open < 0 || close < 0 || close <= open {
-			log.Fatalln("invalid format, expected imm(reg)")
-		}
-		offset := operands[1][:open]
-		addr := operands[1][open+1 : close]
-		rs2, inRs2 = regMap[addr]
-		if !inRs1 && !inRs2 {
-			log.Fatalln("invalid S registers")
-		}
-
-		//check for equ
-		immediate := ilen(0)
-		val, ok := valueTable[offset]
-		if ok {
-			immediate = val
-		} else {
-			val, err := strconv.Atoi(offset)
-			immediate = ilen(val)
-			if err != nil {
-				log.Fatalf("cannot convert val into immediate")
-			}
+		if err := operand_count(mnemonic, operands, 2); err != nil {
+			return err
+		}
+		rs2, err := reg_operand(operands[0])
+		if err != nil {
+			return err
+		}
+		immediate, rs1, err := mem_operand(operands[1], curr_idx)
+		if err != nil {
+			return fmt.Errorf("%s: %w", mnemonic, err)
+		}
+		if offset, ok := gp_relative(operands[1], curr_idx); ok {
+			rs1, immediate = regMap["gp"], offset
+		}
+		if err := check_imm(mnemonic, immediate, sRange); err != nil {
+			return err
 		}
-		first_imm = immediate & 0b11111
-		sec_imm = immediate & 0b11111110000
+		first_imm := immediate & 0b11111   // imm[4:0]
+		sec_imm := (immediate >> 5) & 0x7F // imm[11:5]
 
 		instruction |= ilen(itype.Opcode)
 		instruction |= ilen(first_imm) << 7
 		instruction |= ilen(itype.funct3) << 12
 		instruction |= ilen(rs1) << 15
 		instruction |= ilen(rs2) << 20
-		instruction |= ilen(sec_imm) << 25 //0000000 01010 00110 000 01010 0100011
+		instruction |= ilen(sec_imm) << 25 //0000000 00110 01010 000 01010 0100011
 		populate_bin_instruction(instruction, instr_addresses[curr_idx], bin_arr)
 		fmt.Printf("S instr: %032b\n", instruction)
 	case B: // branch: rs1, rs2, label
-		var operands = strings.SplitN(op_split[1], ", ", 3)
-		var rs1, inRs1 = regMap[operands[0]]
-		var rs2, inRs2 = regMap[operands[1]]
-		var immediate uint32
-		if !inRs1 && !inRs2 {
-			log.Fatalln("B registers not valid")
-		}
-		val, err := strconv.ParseInt(operands[2], 0, 64)
-		immediate = uint32(val)
+		if err := operand_count(mnemonic, operands, 3); err != nil {
+			return err
+		}
+		rs1, err := reg_operand(operands[0])
 		if err != nil {
-			//check if immediate is equ
-			val, ok := valueTable[operands[2]]
-			if ok {
-				immediate = uint32(val)
-				if val > 4094 {
-					log.Fatalln("immediate doesn't fit in 13 bits")
-				}
-				goto valid_b_immediate
-			}
-			//check if immediate is a label
-			symbol, ok := symbolTable[operands[2]]
-			if ok {
-				symb_addr := symbol.offset + symbol.section.addr
-				offset := int32(symb_addr) - int32(instr_addresses[curr_idx])
-				immediate = uint32(offset)
-				if offset < -4096 || offset > 4094 {
-					log.Fatalln("immediate doesn't fit in 13 bits")
-				}
-				goto valid_b_immediate
-			}
-			log.Fatalf("J instr: %s\n", err)
-		} else {
-			//sign extend 2's complement to 16 bits
-			bitmask := (1 << 13) - 1
-			val := uint64(immediate) & uint64(bitmask) // keep lower 13 bits
-			signBit13 := uint64(1 << (13 - 1))
-			if val&signBit13 != 0 { // sign‑extend to 64 bits
-				val |= ^uint64((1 << 13) - 1)
-			}
-			offset := int64(val)
-			immediate = uint32(offset)
-			if offset < -4096 || offset > 4094 {
-				log.Fatalf("immediate 0b%016b(%d) does not fit in signed 13 bits", offset, offset)
-			}
-		}
-	valid_b_immediate:
-		//imm[0] can be dropped because all instructions are byte aligned
-		imm_4_1 := (immediate & 0b0000000011110) >> 1
-		imm_5_10 := (immediate & 0b0011111100000) >> 5
-		imm_11 := (immediate & 0b0100000000000) >> 11
-		imm_12 := (immediate & 0b1000000000000) >> 12
-		//fmt.Printf("imm: %013b(%d) -> %01b %04b %06b %01b\n", uint16(immediate), int16(immediate), imm_11, imm_4_1, imm_5_10, imm_12)
-
-		instruction |= ilen(itype.Opcode)
-		instruction |= ilen(imm_11) << 7
-		instruction |= ilen(imm_4_1) << 8
-		instruction |= ilen(itype.funct3) << 12
-		instruction |= ilen(rs1) << 15
-		instruction |= ilen(rs2) << 20
-		instruction |= ilen(imm_5_10) << 25 //0000000 01100 00101 001 1000 0 1100011
-		instruction |= ilen(imm_12) << 31
+			return err
+		}
+		rs2, err := reg_operand(operands[1])
+		if err != nil {
+			return err
+		}
+		if relaxLevels[curr_idx] != relaxNone {
+			return emit_far_branch(curr_idx, bin_arr, itype, rs1, rs2, operands[2])
+		}
+		offset, err := pc_offset(operands[2], curr_idx)
+		if err != nil {
+			return fmt.Errorf("%s: %w", mnemonic, err)
+		}
+		if err := check_imm(mnemonic, offset, bRange); err != nil {
+			return err
+		}
+		instruction = pack_b(itype.Opcode, itype.funct3, rs1, rs2, offset)
 		fmt.Printf("B instr: %032b\n", instruction)
 		populate_bin_instruction(instruction, instr_addresses[curr_idx], bin_arr)
 	case U: // upper-immediate: rd, imm
-		var operands = strings.SplitN(op_split[1], ", ", 2)
-		var rd, inRd = regMap[operands[0]]
-		if !inRd {
-			log.Fatalf("U register is wrong")
-		}
-		//check if immediate is equ
-		immediate := uint32(0)
-		val, ok := valueTable[operands[1]]
-		if ok {
-			immediate = uint32(val)
-		} else {
-			temp, err := strconv.ParseInt(operands[1], 0, 64)
-			immediate = uint32(temp)
-			if err != nil {
-				log.Fatalf("U instr: %s\n", err)
-			}
+		if err := operand_count(mnemonic, operands, 2); err != nil {
+			return err
 		}
-		//fmt.Printf("imm: %032b(%d) -> %020b \n", immediate, immediate, immediate>>12)
-		// only take 12 bits from immediate
-		immediate = immediate >> 12
-		instruction |= ilen(itype.Opcode)
-		instruction |= ilen(rd) << 7
-		instruction |= ilen(immediate) << 12
+		if instr_sizes[curr_idx] == 0 {
+			return nil //%hi was relaxed away, the %lo users go through gp
+		}
+		rd, err := reg_operand(operands[0])
+		if err != nil {
+			return err
+		}
+		immediate, _, err := imm_operand(operands[1], curr_idx)
+		if err != nil {
+			return fmt.Errorf("%s: %w", mnemonic, err)
+		}
+		// the operand is already the upper 20 bits
+		if err := check_imm(mnemonic, immediate, uRange); err != nil {
+			return err
+		}
+		instruction = pack_u(itype.Opcode, rd, immediate)
 		fmt.Printf("U instr: %032b\n", instruction)
 		populate_bin_instruction(instruction, instr_addresses[curr_idx], bin_arr)
 
 	case J: // jump: rd, label
-		var operands = strings.SplitN(op_split[1], ", ", 2)
-		var rd, inRd = regMap[operands[0]]
-		var immediate uint32
-		if !inRd {
-			log.Fatalf("J register is wrong")
-		}
-		val, err := strconv.ParseInt(operands[1], 0, 64)
-		immediate = uint32(val)
+		if err := operand_count(mnemonic, operands, 2); err != nil {
+			return err
+		}
+		rd, err := reg_operand(operands[0])
 		if err != nil {
-			//check if immediate is equ
-			val, ok := valueTable[operands[1]]
-			if ok {
-				immediate = uint32(val)
-				goto valid_j_immediate
-			}
-			//check if immediate is a label
-			symbol, ok := symbolTable[operands[1]]
-			if ok {
-				symb_addr := symbol.offset + symbol.section.addr
-				offset := int32(symb_addr) - int32(instr_addresses[curr_idx])
-				immediate = uint32(offset)
-				goto valid_j_immediate
-			}
-			log.Fatalf("J instr: %s\n", err)
+			return err
 		}
-	valid_j_immediate:
-		//split immediate into J format
-		imm_12_19 := (immediate >> 12) & 0xFF
-		imm_11 := (immediate >> 11) & 0x1
-		imm_1_10 := (immediate >> 1) & 0x3FF
-		imm_20 := (immediate >> 20) & 0x1
-		//fmt.Printf("imm: %032b(%d) %08b %01b %010b %01b\n", immediate, immediate, imm_12_19, imm_11, imm_1_10, imm_20)
-
-		instruction |= ilen(itype.Opcode)
-		instruction |= ilen(rd) << 7
-		instruction |= ilen(imm_12_19) << 12
-		instruction |= ilen(imm_11) << 20
-		instruction |= ilen(imm_1_10) << 21
-		instruction |= ilen(imm_20) << 31 //0 0111010101 1 00001010 00110 1101111
+		if relaxLevels[curr_idx] != relaxNone {
+			return emit_far_jump(curr_idx, bin_arr, rd, operands[1])
+		}
+		offset, err := pc_offset(operands[1], curr_idx)
+		if err != nil {
+			return fmt.Errorf("%s: %w", mnemonic, err)
+		}
+		if err := check_imm(mnemonic, offset, jRange); err != nil {
+			return err
+		}
+		instruction = pack_j(itype.Opcode, rd, offset)
 		fmt.Printf("J instr: %032b\n", instruction)
 		populate_bin_instruction(instruction, instr_addresses[curr_idx], bin_arr)
 
+	case V: // vector: operands follow from the fields, see vector_operands
+		instruction, err := encode_vector(curr_idx, mnemonic, itype, operands)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("V instr: %032b\n", instruction)
+		populate_bin_instruction(instruction, instr_addresses[curr_idx], bin_arr)
+
+	case F: // floating point: operands follow from the fields, see float_operands
+		instruction, err := encode_float(curr_idx, mnemonic, itype, operands)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("F instr: %032b\n", instruction)
+		populate_bin_instruction(instruction, instr_addresses[curr_idx], bin_arr)
+
+	case C: // system: operands follow from the fields, see system_operands
+		instruction, err := encode_system(curr_idx, mnemonic, itype, operands)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("C instr: %032b\n", instruction)
+		populate_bin_instruction(instruction, instr_addresses[curr_idx], bin_arr)
+
+	case A: // atomics: ordering from the mnemonic suffix, see atomic_base
+		instruction, err := encode_atomic(curr_idx, mnemonic, itype, operands)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("A instr: %032b\n", instruction)
+		populate_bin_instruction(instruction, instr_addresses[curr_idx], bin_arr)
+
 	default:
-		log.Fatalf("unsupported instruction format %q", itype.fmt)
+		return fmt.Errorf("unsupported instruction format %q", itype.fmt)
 	}
-	return next_addr, nil
-} // Instruction & labels
+	return nil
+}
 
 // rounds v up to instruction length
 func align_addr(v ilen) ilen {
@@ -663,9 +860,49 @@ func align_size(v reg, sz reg) reg {
 	return (v + (sz - 1)) &^ (sz - 1)
 }
 
+// numeric labels like 1: can be defined many times and are referenced as 1b/1f
+func is_local_label(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// returns the absolute address of a label. Nb refers to the closest definition of N before line curr_idx, Nf to the closest after
+func resolve_label(name string, curr_idx int) (ilen, bool) {
+	if n := len(name); n >= 2 && (name[n-1] == 'b' || name[n-1] == 'f') && is_local_label(name[:n-1]) {
+		defs := localLabels[name[:n-1]]
+		if name[n-1] == 'b' {
+			for i := len(defs) - 1; i >= 0; i-- {
+				if defs[i] < curr_idx {
+					return instr_addresses[defs[i]], true
+				}
+			}
+		} else {
+			for _, def := range defs {
+				if def > curr_idx {
+					return instr_addresses[def], true
+				}
+			}
+		}
+		return 0, false
+	}
+	symbol, ok := symbolTable[name]
+	if !ok || symbol.section == nil {
+		return 0, false
+	}
+	return symbol.offset + symbol.section.addr, true
+}
+
+// RISC-V is little endian: the least significant byte of an instruction goes first
 func populate_bin_instruction(instruction ilen, addr ilen, byte_arr []byte) {
 	for i := ilen(0); i < ILEN_BYTES; i++ {
-		ibyte := (instruction >> (8 * (ILEN_BYTES - i - 1))) & 0xFF
+		ibyte := (instruction >> (8 * i)) & 0xFF
 		//fmt.Printf("%08b, ", ibyte)
 		byte_arr[addr+i] = byte(ibyte)
 	}
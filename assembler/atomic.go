@@ -0,0 +1,74 @@
+package assembler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A instructions: lr.w rd, (rs1), sc.w and the amos rd, rs2, (rs1). The aq and rl bits are not operands but a
+// suffix on the mnemonic (amoadd.w.aqrl), so every ordering shares the one InstrTable entry
+
+// aq (bit 26) and rl (bit 25) of each mnemonic suffix
+var atomicOrderings = map[string]uint32{".aq": 2, ".rl": 1, ".aqrl": 3}
+
+// the InstrTable name of an atomic mnemonic and its aq/rl bits. Anything else comes back as it is
+func atomic_base(mnemonic string) (string, uint32) {
+	for suffix, order := range atomicOrderings {
+		if base, ok := strings.CutSuffix(mnemonic, suffix); ok && InstrTable[base].has_arg("aq") {
+			return base, order
+		}
+	}
+	return mnemonic, 0
+}
+
+// the fields of an atomic instruction in the order they are written
+func atomic_operands(desc InstrDesc) []string {
+	if desc.has_arg("rs2") {
+		return []string{"rd", "rs2", "mem"}
+	}
+	return []string{"rd", "mem"}
+}
+
+func encode_atomic(curr_idx int, mnemonic string, itype InstrDesc, operands []string) (ilen, error) {
+	fields := atomic_operands(itype)
+	if err := operand_count(mnemonic, operands, len(fields)); err != nil {
+		return 0, err
+	}
+	_, order := atomic_base(mnemonic)
+	word := itype.match | order<<operandFields["rl"].lo
+	for i, field := range fields {
+		var reg uint8
+		var err error
+		if field == "mem" {
+			var offset int64
+			offset, reg, err = mem_operand(operands[i], curr_idx)
+			if err == nil && offset != 0 {
+				err = fmt.Errorf("%s: atomics take no offset, got %d", mnemonic, offset)
+			}
+			field = "rs1"
+		} else {
+			reg, err = reg_operand(operands[i])
+		}
+		if err != nil {
+			return 0, err
+		}
+		word |= uint32(reg) << operandFields[field].lo
+	}
+	return ilen(word), nil
+}
+
+// text of an atomic instruction with its ordering suffix, in the order encode_atomic takes it
+func decode_atomic(name string, desc InstrDesc, word uint32) string {
+	order := (word >> operandFields["rl"].lo) & 0x3
+	for suffix, bits := range atomicOrderings {
+		if bits == order {
+			name += suffix
+		}
+	}
+	ops := []string{reg_name(word >> 7)}
+	if desc.has_arg("rs2") {
+		ops = append(ops, reg_name(word>>20))
+	}
+	ops = append(ops, fmt.Sprintf("(%s)", reg_name(word>>15)))
+	return name + " " + strings.Join(ops, ", ")
+}
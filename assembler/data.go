@@ -0,0 +1,167 @@
+package assembler
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// bytes per operand of each data directive
+var dataWidths = map[string]int{
+	".byte":     1,
+	".half":     2,
+	".short":    2,
+	".2byte":    2,
+	".word":     4,
+	".long":     4,
+	".4byte":    4,
+	".dword":    8,
+	".quad":     8,
+	".8byte":    8,
+	".float16":  2,
+	".bfloat16": 2,
+	".float":    4,
+	".double":   8,
+}
+
+// comma separated operands of a data directive
+func data_operands(op_split []string) ([]string, error) {
+	if len(op_split) < 2 || strings.TrimSpace(op_split[1]) == "" {
+		return nil, fmt.Errorf("%s needs at least one operand", op_split[0])
+	}
+	words := split_operands(op_split[1])
+	for _, word := range words {
+		if word == "" {
+			return nil, fmt.Errorf("%s has an empty operand", op_split[0])
+		}
+	}
+	return words, nil
+}
+
+// unquoted string operands of .ascii/.asciz/.string
+func parse_strings(op_split []string) ([]string, error) {
+	words, err := data_operands(op_split)
+	if err != nil {
+		return nil, err
+	}
+	strs := make([]string, len(words))
+	for i, word := range words {
+		strs[i], err = strconv.Unquote(word)
+		if err != nil || word[0] != '"' {
+			return nil, fmt.Errorf("%s operand %s is not a quoted string", op_split[0], word)
+		}
+	}
+	return strs, nil
+}
+
+// a float literal, or an expression for its integer value. A literal too big for bits is an error rather than
+// something eval_expr gets to misread
+func parse_float(directive string, word string, bits int, curr_idx int) (float64, error) {
+	f, err := strconv.ParseFloat(word, bits)
+	var num_err *strconv.NumError
+	if errors.As(err, &num_err) && num_err.Err == strconv.ErrRange {
+		return 0, fmt.Errorf("%s: %s is out of range", directive, word)
+	}
+	if err != nil {
+		val, err := eval_expr(word, curr_idx)
+		if err != nil {
+			return 0, err
+		}
+		f = float64(val)
+	}
+	return f, nil
+}
+
+// value of one data operand. Integers are expressions and may be negative (stored in two's complement),
+// .float16/.float/.double operands are IEEE-754, .bfloat16 ones the upper half of a .float
+func parse_data_value(directive string, word string, curr_idx int) (uint64, error) {
+	switch directive {
+	case ".float16", ".bfloat16":
+		f, err := parse_float(directive, word, 64, curr_idx)
+		if err != nil {
+			return 0, err
+		}
+		if directive == ".float16" {
+			return narrow_float(f, 5, 10), nil
+		}
+		return narrow_float(f, 8, 7), nil
+	case ".float":
+		f, err := parse_float(directive, word, 32, curr_idx)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(math.Float32bits(float32(f))), nil
+	case ".double":
+		f, err := parse_float(directive, word, 64, curr_idx)
+		if err != nil {
+			return 0, err
+		}
+		return math.Float64bits(f), nil
+	}
+	val, err := eval_expr(word, curr_idx)
+	if err != nil {
+		return 0, err
+	}
+	bits := dataWidths[directive] * 8
+	if bits < 64 && (val < -(1<<(bits-1)) || val >= 1<<bits) {
+		return 0, fmt.Errorf("value %d does not fit in %d bits", val, bits)
+	}
+	return uint64(val), nil
+}
+
+// f rounded to nearest even in a binary format with exp_bits of exponent and man_bits of mantissa. Too large
+// values become infinity, too small ones subnormal or zero, NaNs the quiet NaN
+func narrow_float(f float64, exp_bits, man_bits uint) uint64 {
+	sign := math.Float64bits(f) >> 63 << (exp_bits + man_bits)
+	inf := sign | (1<<exp_bits-1)<<man_bits
+	switch {
+	case math.IsNaN(f):
+		return inf | 1<<(man_bits-1)
+	case math.IsInf(f, 0):
+		return inf
+	case f == 0:
+		return sign
+	}
+	bias := 1<<(exp_bits-1) - 1
+	_, exp := math.Frexp(math.Abs(f))
+	exp = max(exp-1, 1-bias) //subnormals share the exponent of the smallest normal
+	// the mantissa with its leading one. Rounding up into the next exponent carries into the exponent field
+	man := uint64(math.RoundToEven(math.Ldexp(math.Abs(f), int(man_bits)-exp)))
+	bits := uint64(exp+bias-1)<<man_bits + man
+	if bits >= 1<<(exp_bits+man_bits)-1<<man_bits {
+		return inf
+	}
+	return sign | bits
+}
+
+// writes the low width bytes of val least significant byte first, same as populate_bin_instruction
+func put_bytes(bin_arr []byte, val uint64, width int) {
+	for i := 0; i < width; i++ {
+		bin_arr[i] = byte(val >> (8 * i))
+	}
+}
+
+// (un)signed LEB128 encoding used by .uleb128/.sleb128
+func encode_leb128(val int64, signed bool) []byte {
+	var out []byte
+	uval := uint64(val)
+	for {
+		b := byte(uval & 0x7F)
+		if signed {
+			val >>= 7
+			done := (val == 0 && b&0x40 == 0) || (val == -1 && b&0x40 != 0)
+			if done {
+				return append(out, b)
+			}
+			uval = uint64(val)
+		} else {
+			uval >>= 7
+			if uval == 0 {
+				return append(out, b)
+			}
+		}
+		out = append(out, b|0x80)
+	}
+}
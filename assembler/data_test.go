@@ -0,0 +1,70 @@
+package assembler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFloatRange(t *testing.T) {
+	for _, line := range []string{".float 1e40", ".float -1e40", ".double 1e400", ".float16 1e400", ".bfloat16 -1e400"} {
+		if _, err := Assemble([]string{".data", line}); err == nil || !strings.Contains(err.Error(), "out of range") {
+			t.Errorf("%q: %v", line, err)
+		}
+	}
+	for _, line := range []string{".float 3.4e38", ".double 1.7e308", ".float 1e-50"} {
+		if _, err := Assemble([]string{".data", line}); err != nil {
+			t.Errorf("%q: %v", line, err)
+		}
+	}
+}
+
+func TestStringSize(t *testing.T) {
+	cases := []struct {
+		line string
+		size int
+	}{
+		{`.ascii "abc"`, 3},
+		{`.asciz "abc"`, 4},
+		{`.asciz "hi"`, 3},
+		{`.string "a", "bc"`, 5},
+		{`.asciz ""`, 1},
+	}
+	for _, c := range cases {
+		bin, err := Assemble([]string{".data", c.line, "end:"})
+		if err != nil {
+			t.Errorf("%q: %v", c.line, err)
+			continue
+		}
+		if end, err := lookup_symbol("end", 0); err != nil || end-int64(sectionTable[".data"].addr) != int64(c.size) {
+			t.Errorf("%q: %d bytes (image %d), want %d", c.line, end-int64(sectionTable[".data"].addr), len(bin), c.size)
+		}
+	}
+}
+
+// spaces and # inside a string are part of it, not whitespace to collapse or a comment
+func TestStringSource(t *testing.T) {
+	cases := []struct {
+		src  string
+		want string
+	}{
+		{".data\n.ascii \"a  b\"", "a  b"},
+		{".data\n.ascii \"a#b\" # comment", "a#b"},
+		{".data\n.ascii \"a\\\"#\", \"\t\"", "a\"#\t"},
+		{".data\n.byte '#', ' '   # comment", "# "},
+	}
+	for _, c := range cases {
+		lines, err := ParseSource(c.src)
+		if err != nil {
+			t.Errorf("%q: %v", c.src, err)
+			continue
+		}
+		bin, err := Assemble(lines)
+		if err != nil {
+			t.Errorf("%q: %v", c.src, err)
+			continue
+		}
+		if data := string(bin[sectionTable[".data"].addr:]); data != c.want {
+			t.Errorf("%q: %q, want %q", c.src, data, c.want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package assembler
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Disassembler driven by InstrTable: every word is matched back to a mnemonic by the fixed bits from opcodes/.
+// Branch and jump targets are printed as offsets (what the assembler takes) with the address and label in a
+// comment, so an instruction column can be fed back into the assembler. Words that match nothing become .word
+
+// Disassemble lists code loaded at base. Words are read little endian, as SecondPass writes them, and labels
+// come from the symbol table of the last assembly (if any)
+func Disassemble(code []byte, base uint64) string {
+	return disassemble(code, base, binary.LittleEndian, symbol_labels())
+}
+
+// DisassembleELF lists every executable section of an ELF file, labelled from its own symbol table
+func DisassembleELF(filename string) (string, error) {
+	f, err := elf.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if f.Machine != elf.EM_RISCV {
+		return "", fmt.Errorf("%s is not a RISC-V ELF (%s)", filename, f.Machine)
+	}
+	labels := make(map[uint64][]string)
+	syms, _ := f.Symbols() //no symbol table just means no labels
+	for _, sym := range syms {
+		if sym.Name != "" && sym.Section > elf.SHN_UNDEF && sym.Section < elf.SHN_LORESERVE {
+			labels[sym.Value] = append(labels[sym.Value], sym.Name)
+		}
+	}
+	var out strings.Builder
+	for _, sec := range f.Sections {
+		if sec.Flags&elf.SHF_EXECINSTR == 0 || sec.Type != elf.SHT_PROGBITS {
+			continue
+		}
+		data, err := sec.Data()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&out, "Disassembly of section %s:\n", sec.Name)
+		out.WriteString(disassemble(data, sec.Addr, f.ByteOrder, labels))
+		out.WriteString("\n")
+	}
+	return out.String(), nil
+}
+
+// defined symbols of the last assembly by address
+func symbol_labels() map[uint64][]string {
+	labels := make(map[uint64][]string)
+	for _, sym := range symbolTable {
+		if sym.section != nil && sym.section.flags&SecAlloc != 0 {
+			addr := uint64(sym.section.addr + sym.offset)
+			labels[addr] = append(labels[addr], sym.name)
+		}
+	}
+	return labels
+}
+
+func disassemble(code []byte, base uint64, order binary.ByteOrder, labels map[uint64][]string) string {
+	var out strings.Builder
+	table := decode_table()
+	for off := 0; off < len(code); off += int(ILEN_BYTES) {
+		addr := base + uint64(off)
+		names := labels[addr]
+		sort.Strings(names)
+		if len(names) > 0 {
+			out.WriteString("\n")
+		}
+		for _, name := range names {
+			fmt.Fprintf(&out, "%08x <%s>:\n", addr, name)
+		}
+		if len(code)-off < int(ILEN_BYTES) {
+			bytes := make([]string, 0, len(code)-off)
+			for _, b := range code[off:] {
+				bytes = append(bytes, fmt.Sprintf("0x%02x", b))
+			}
+			fmt.Fprintf(&out, "%8x:\t%-8s\t.byte %s\n", addr, fmt.Sprintf("%x", code[off:]), strings.Join(bytes, ", "))
+			break
+		}
+		word := order.Uint32(code[off:])
+		text, target, ok := table.decode(word, addr)
+		if !ok {
+			text = fmt.Sprintf(".word 0x%08x", word)
+		} else if target >= 0 {
+			text += fmt.Sprintf(" # 0x%x", target)
+			if names := labels[uint64(target)]; len(names) > 0 {
+				sort.Strings(names)
+				text += fmt.Sprintf(" <%s>", names[0])
+			}
+		}
+		fmt.Fprintf(&out, "%8x:\t%08x\t%s\n", addr, word, text)
+	}
+	return out.String()
+}
+
+// mnemonics in the order they are tried
+type decoder struct {
+	order []string
+}
+
+func decode_table() *decoder {
+	return &decoder{order: decode_order()}
+}
+
+func reg_name(r uint32) string {
+	return abiNames[r&0x1F]
+}
+
+// text of one instruction. target is the absolute address of a branch/jump, -1 for everything else
+func (d *decoder) decode(word uint32, addr uint64) (string, int64, bool) {
+	rd := (word >> 7) & 0x1F
+	rs1 := (word >> 15) & 0x1F
+	rs2 := (word >> 20) & 0x1F
+	name := ""
+	for _, cand := range d.order {
+		if desc := InstrTable[cand]; word&desc.mask == desc.match {
+			name = cand
+			break
+		}
+	}
+	if name == "" {
+		return "", -1, false
+	}
+	desc := InstrTable[name]
+
+	switch desc.fmt {
+	case R:
+		if desc.has_arg("bs") {
+			return fmt.Sprintf("%s %s, %s, %s, %d", name, reg_name(rd), reg_name(rs1), reg_name(rs2), word>>30), -1, true
+		}
+		return fmt.Sprintf("%s %s, %s, %s", name, reg_name(rd), reg_name(rs1), reg_name(rs2)), -1, true
+	case I:
+		imm := int64(int32(word) >> 20)
+		switch {
+		case len(desc.args) == 0:
+			return name, -1, true //ecall, ebreak
+		case desc.has_arg("shamtw"):
+			return fmt.Sprintf("%s %s, %s, %d", name, reg_name(rd), reg_name(rs1), imm&0x1F), -1, true
+		case !desc.has_arg("imm12"):
+			return fmt.Sprintf("%s %s, %s", name, reg_name(rd), reg_name(rs1)), -1, true
+		case !desc.mem:
+			return fmt.Sprintf("%s %s, %s, %d", name, reg_name(rd), reg_name(rs1), imm), -1, true
+		}
+		// loads and jalr
+		return fmt.Sprintf("%s %s, %d(%s)", name, reg_name(rd), imm, reg_name(rs1)), -1, true
+	case S:
+		imm := int64(int32(word)>>25)<<5 | int64(rd)
+		return fmt.Sprintf("%s %s, %d(%s)", name, reg_name(rs2), imm, reg_name(rs1)), -1, true
+	case B:
+		imm := int64(word>>31)<<12 | int64((word>>7)&0x1)<<11 | int64((word>>25)&0x3F)<<5 | int64((word>>8)&0xF)<<1
+		imm = imm << 51 >> 51 //sign extend 13 bits
+		return fmt.Sprintf("%s %s, %s, %d", name, reg_name(rs1), reg_name(rs2), imm), int64(addr) + imm, true
+	case U:
+		return fmt.Sprintf("%s %s, 0x%x", name, reg_name(rd), word>>12), -1, true
+	case V:
+		return decode_vector(name, desc, word), -1, true
+	case F:
+		return decode_float(name, desc, word), -1, true
+	case C:
+		return decode_system(name, desc, word), -1, true
+	case A:
+		return decode_atomic(name, desc, word), -1, true
+	case J:
+		imm := int64(word>>31)<<20 | int64((word>>12)&0xFF)<<12 | int64((word>>20)&0x1)<<11 | int64((word>>21)&0x3FF)<<1
+		imm = imm << 43 >> 43 //sign extend 21 bits
+		return fmt.Sprintf("%s %s, %d", name, reg_name(rd), imm), int64(addr) + imm, true
+	}
+	return "", -1, false
+}
+
+// DisassembleFile lists an ELF file, or a flat binary loaded at base
+func DisassembleFile(filename string, base uint64) (string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	if len(data) >= 4 && string(data[:4]) == elf.ELFMAG {
+		return DisassembleELF(filename)
+	}
+	return Disassemble(data, base), nil
+}
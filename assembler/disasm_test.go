@@ -0,0 +1,78 @@
+package assembler
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDisassembleELF(t *testing.T) {
+	lines := []string{
+		".globl _start",
+		"_start:", "addi a0, zero, 1",
+		"beq a0, zero, done",
+		".word 0xffffffff",
+		"done:", "jal ra, _start",
+		".data", "value:", ".word 0x13",
+		`.section .text.more, "ax"`, "more:", "addi a1, a1, -1",
+	}
+	assemble_image(t, lines)
+	filename := filepath.Join(t.TempDir(), "out.elf")
+	WriteELF(filename)
+	listing, err := DisassembleFile(filename, 0x1000) //the base only matters to flat binaries
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Disassembly of section .text:\n",
+		"00000000 <_start>:\n",
+		"       0:\t00100513\taddi a0, zero, 1\n",
+		"       4:\t00050463\tbeq a0, zero, 8 # 0xc <done>\n",
+		"       8:\tffffffff\t.word 0xffffffff\n",
+		"0000000c <done>:\n",
+		"       c:\tff5ff0ef\tjal ra, -12 # 0x0 <_start>\n",
+		"Disassembly of section .text.more:\n",
+		"00000010 <more>:\n",
+		"      10:\tfff58593\taddi a1, a1, -1\n",
+	} {
+		if !strings.Contains(listing, want) {
+			t.Errorf("listing is missing %q:\n%s", want, listing)
+		}
+	}
+	if strings.Contains(listing, ".data") || strings.Contains(listing, "<value>") {
+		t.Errorf(".data was disassembled:\n%s", listing)
+	}
+}
+
+func TestDisassembleFlat(t *testing.T) {
+	code := []byte{0x13, 0x05, 0x10, 0x00, 0x6f, 0x00, 0x00, 0x00, 0xaa, 0xbb}
+	filename := filepath.Join(t.TempDir(), "out.bin")
+	if err := os.WriteFile(filename, code, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	listing, err := DisassembleFile(filename, 0x80000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "80000000:\t00100513\taddi a0, zero, 1\n" +
+		"80000004:\t0000006f\tjal zero, 0 # 0x80000004\n" +
+		"80000008:\taabb    \t.byte 0xaa, 0xbb\n"
+	if listing != want {
+		t.Errorf("got\n%s\nwant\n%s", listing, want)
+	}
+}
+
+func TestDisassembleRejects(t *testing.T) {
+	if _, err := DisassembleFile(filepath.Join(t.TempDir(), "missing"), 0); err == nil {
+		t.Error("missing file was accepted")
+	}
+	exe, err := os.Executable() //the test binary, an ELF for the host
+	if err != nil || runtime.GOARCH == "riscv64" || runtime.GOOS != "linux" {
+		t.Skip("needs a non RISC-V Linux host")
+	}
+	if _, err := DisassembleELF(exe); err == nil || !strings.Contains(err.Error(), "not a RISC-V ELF") {
+		t.Errorf("%s: %v", exe, err)
+	}
+}
@@ -0,0 +1,380 @@
+package assembler
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+)
+
+// ELF output of the same image SecondPass writes to the .bin. Section bytes are kept exactly as they are in
+// the image, which is little endian like every RISC-V ELF
+type elf_writer struct {
+	buf   bytes.Buffer
+	order binary.ByteOrder
+	is64  bool
+}
+
+func (w *elf_writer) u8(v uint8)   { w.buf.WriteByte(v) }
+func (w *elf_writer) u16(v uint16) { binary.Write(&w.buf, w.order, v) }
+func (w *elf_writer) u32(v uint32) { binary.Write(&w.buf, w.order, v) }
+func (w *elf_writer) u64(v uint64) { binary.Write(&w.buf, w.order, v) }
+
+// addresses, offsets and sizes are 4 or 8 bytes depending on the class
+func (w *elf_writer) word(v uint64) {
+	if w.is64 {
+		w.u64(v)
+	} else {
+		w.u32(uint32(v))
+	}
+}
+
+func (w *elf_writer) pad_to(align int) {
+	for w.buf.Len()%align != 0 {
+		w.buf.WriteByte(0)
+	}
+}
+
+// string table with offsets handed out as names are added
+type strtab struct {
+	data []byte
+	idx  map[string]uint32
+}
+
+func new_strtab() *strtab {
+	return &strtab{data: []byte{0}, idx: map[string]uint32{"": 0}}
+}
+
+func (t *strtab) add(name string) uint32 {
+	if off, ok := t.idx[name]; ok {
+		return off
+	}
+	off := uint32(len(t.data))
+	t.data = append(append(t.data, name...), 0)
+	t.idx[name] = off
+	return off
+}
+
+// SHT_RISCV_ATTRIBUTES, which debug/elf only has from go1.25
+const shtRISCVAttributes elf.SectionType = 0x70000003
+
+// one section header's worth of information
+type elf_section struct {
+	name      string
+	typ       elf.SectionType
+	flags     elf.SectionFlag
+	addr      uint64
+	data      []byte
+	size      uint64 //differs from len(data) for NOBITS
+	align     uint64
+	link      uint32
+	info      uint32
+	entsize   uint64
+	offset    uint64
+	src       *Section
+	name_off  uint32
+	has_bytes bool
+}
+
+// bytes of a section: allocated ones are a window into the image, the rest were kept separately
+func section_contents(sec *Section) []byte {
+	if sec.nobits {
+		return nil
+	}
+	if sec.flags&SecAlloc == 0 {
+		return sec.data
+	}
+	return bin_image[sec.addr : sec.addr+sec.sz]
+}
+
+func elf_section_flags(sec *Section) elf.SectionFlag {
+	var flags elf.SectionFlag
+	if sec.flags&SecAlloc != 0 {
+		flags |= elf.SHF_ALLOC
+	}
+	if sec.flags&SecWrite != 0 {
+		flags |= elf.SHF_WRITE
+	}
+	if sec.flags&SecExec != 0 {
+		flags |= elf.SHF_EXECINSTR
+	}
+	return flags
+}
+
+// writes an executable ELF with one PT_LOAD per allocated section, permissions taken from the section flags,
+// plus a symbol table. Must run after SecondPass
+func WriteELF(filename string) {
+	w := &elf_writer{order: binary.LittleEndian, is64: false}
+	shstr := new_strtab()
+	str := new_strtab()
+
+	sections := []*elf_section{{}} //index 0 is SHN_UNDEF
+	index := make(map[*Section]int)
+	for _, name := range sectionOrder {
+		sec := sectionTable[name]
+		typ := elf.SHT_PROGBITS
+		if sec.nobits {
+			typ = elf.SHT_NOBITS
+		}
+		index[sec] = len(sections)
+		sections = append(sections, &elf_section{
+			name:      name,
+			typ:       typ,
+			flags:     elf_section_flags(sec),
+			addr:      uint64(sec.addr),
+			data:      section_contents(sec),
+			size:      uint64(sec.sz),
+			align:     uint64(max(sec.align, ILEN_BYTES)),
+			src:       sec,
+			has_bytes: !sec.nobits,
+		})
+	}
+
+	// locals have to come before globals. .equ/.set values are absolute symbols
+	symbols := make([]*Symbol, 0, len(symbolTable)+len(valueTable))
+	for _, sym := range symbolTable {
+		if _, ok := valueTable[sym.name]; !ok {
+			symbols = append(symbols, sym)
+		}
+	}
+	for name := range valueTable {
+		if is_predefined_value(name) { //-fsyscalls constants nobody changed
+			continue
+		}
+		sym := &Symbol{name: name}
+		if declared, ok := symbolTable[name]; ok {
+			*sym = *declared
+			sym.section = nil
+		}
+		symbols = append(symbols, sym)
+	}
+	is_local := func(sym *Symbol) bool { return !sym.global && !sym.weak }
+	sort.Slice(symbols, func(i, j int) bool {
+		if is_local(symbols[i]) != is_local(symbols[j]) {
+			return is_local(symbols[i])
+		}
+		return symbols[i].name < symbols[j].name
+	})
+	sym_size := 16
+	if w.is64 {
+		sym_size = 24
+	}
+	symw := &elf_writer{order: w.order, is64: w.is64}
+	symw.buf.Write(make([]byte, sym_size)) //null symbol
+	first_global := uint32(len(symbols) + 1)
+	for i, sym := range symbols {
+		if !is_local(sym) && first_global > uint32(i+1) {
+			first_global = uint32(i + 1)
+		}
+		bind := elf.STB_LOCAL
+		if sym.weak {
+			bind = elf.STB_WEAK
+		} else if sym.global {
+			bind = elf.STB_GLOBAL
+		}
+		typ := elf.STT_NOTYPE
+		switch sym.typ {
+		case SymFunc:
+			typ = elf.STT_FUNC
+		case SymObject:
+			typ = elf.STT_OBJECT
+		}
+		shndx := uint16(elf.SHN_UNDEF)
+		value := uint64(0)
+		if val, ok := valueTable[sym.name]; ok {
+			shndx = uint16(elf.SHN_ABS)
+			value = uint64(val)
+			if !w.is64 && (val < math.MinInt32 || val > math.MaxUint32) { //st_value is 32 bits wide
+				fmt.Fprintf(os.Stderr, "warning: %s = %#x is truncated to 32 bits in the symbol table\n", sym.name, val)
+			}
+		} else if sym.section != nil {
+			shndx = uint16(index[sym.section])
+			value = uint64(sym.section.addr + sym.offset)
+		}
+		other := uint8(sym.vis) //STV_* share our numbering
+		write_elf_symbol(symw, str.add(sym.name), value, uint64(sym.size), elf.ST_INFO(bind, typ), other, shndx)
+	}
+
+	symtab_idx := len(sections)
+	sections = append(sections,
+		&elf_section{name: ".symtab", typ: elf.SHT_SYMTAB, data: symw.buf.Bytes(), align: 4, link: uint32(symtab_idx + 1), info: first_global, entsize: uint64(sym_size), has_bytes: true},
+		&elf_section{name: ".strtab", typ: elf.SHT_STRTAB, data: str.data, align: 1, has_bytes: true},
+	)
+	sections = append(sections, &elf_section{name: ".riscv.attributes", typ: shtRISCVAttributes, data: riscv_attributes(w.order), align: 1, has_bytes: true})
+	sections = append(sections, &elf_section{name: ".shstrtab", typ: elf.SHT_STRTAB, align: 1, has_bytes: true})
+	for _, sec := range sections[1:] {
+		sec.name_off = shstr.add(sec.name)
+	}
+	sections[len(sections)-1].data = shstr.data
+	for _, sec := range sections[1:] {
+		if sec.typ != elf.SHT_NOBITS {
+			sec.size = uint64(len(sec.data))
+		}
+	}
+
+	// one loadable segment per allocated section that has a size
+	var loads []*elf_section
+	for _, sec := range sections[1:] {
+		if sec.flags&elf.SHF_ALLOC != 0 && sec.size > 0 {
+			loads = append(loads, sec)
+		}
+	}
+
+	ehsize, phentsize, shentsize := 52, 32, 40
+	if w.is64 {
+		ehsize, phentsize, shentsize = 64, 56, 64
+	}
+	offset := uint64(ehsize + phentsize*len(loads))
+	for _, sec := range sections[1:] {
+		if !sec.has_bytes {
+			sec.offset = offset
+			continue
+		}
+		offset = (offset + sec.align - 1) &^ (sec.align - 1)
+		sec.offset = offset
+		offset += uint64(len(sec.data))
+	}
+	shoff := (offset + 7) &^ 7
+
+	entry := uint64(sectionTable[".text"].addr)
+	if start, ok := symbolTable["_start"]; ok && start.section != nil {
+		entry = uint64(start.section.addr + start.offset)
+	}
+
+	// ELF header
+	class := elf.ELFCLASS32
+	if w.is64 {
+		class = elf.ELFCLASS64
+	}
+	w.buf.Write([]byte{0x7F, 'E', 'L', 'F', byte(class), byte(elf.ELFDATA2LSB), byte(elf.EV_CURRENT), byte(elf.ELFOSABI_NONE)})
+	w.buf.Write(make([]byte, 8))
+	w.u16(uint16(elf.ET_EXEC))
+	w.u16(uint16(elf.EM_RISCV))
+	w.u32(uint32(elf.EV_CURRENT))
+	w.word(entry)
+	w.word(uint64(ehsize))
+	w.word(shoff)
+	w.u32(elf_header_flags())
+	w.u16(uint16(ehsize))
+	w.u16(uint16(phentsize))
+	w.u16(uint16(len(loads)))
+	w.u16(uint16(shentsize))
+	w.u16(uint16(len(sections)))
+	w.u16(uint16(len(sections) - 1)) //.shstrtab is last
+
+	// program headers
+	for _, sec := range loads {
+		flags := elf.PF_R
+		if sec.flags&elf.SHF_WRITE != 0 {
+			flags |= elf.PF_W
+		}
+		if sec.flags&elf.SHF_EXECINSTR != 0 {
+			flags |= elf.PF_X
+		}
+		filesz := uint64(len(sec.data))
+		w.u32(uint32(elf.PT_LOAD))
+		if w.is64 {
+			w.u32(uint32(flags))
+		}
+		w.word(sec.offset)
+		w.word(sec.addr)
+		w.word(sec.addr)
+		w.word(filesz)
+		w.word(sec.size)
+		if !w.is64 {
+			w.u32(uint32(flags))
+		}
+		w.word(sec.align)
+	}
+
+	// section contents
+	for _, sec := range sections[1:] {
+		if !sec.has_bytes {
+			continue
+		}
+		w.buf.Write(make([]byte, int(sec.offset)-w.buf.Len()))
+		w.buf.Write(sec.data)
+	}
+	w.pad_to(8)
+
+	// section headers
+	for _, sec := range sections {
+		w.u32(sec.name_off)
+		w.u32(uint32(sec.typ))
+		w.word(uint64(sec.flags))
+		w.word(sec.addr)
+		w.word(sec.offset)
+		w.word(sec.size)
+		w.u32(sec.link)
+		w.u32(sec.info)
+		w.word(sec.align)
+		w.word(sec.entsize)
+	}
+
+	err := os.WriteFile(filename, w.buf.Bytes(), 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func write_elf_symbol(w *elf_writer, name uint32, value uint64, size uint64, info uint8, other uint8, shndx uint16) {
+	w.u32(name)
+	if w.is64 {
+		w.u8(info)
+		w.u8(other)
+		w.u16(shndx)
+		w.u64(value)
+		w.u64(size)
+		return
+	}
+	w.u32(uint32(value))
+	w.u32(uint32(size))
+	w.u8(info)
+	w.u8(other)
+	w.u16(shndx)
+}
+
+// .riscv.attributes: format version 'A', then a "riscv" subsection whose Tag_File part lists every attribute as
+// a ULEB128 tag followed by a ULEB128 number or a NUL terminated string
+func riscv_attributes(order binary.ByteOrder) []byte {
+	attrs := map[uint64]attribute{tagRISCVArch: {str: activeISA.String()}}
+	for tag, attr := range attributes {
+		attrs[tag] = attr
+	}
+	tags := make([]uint64, 0, len(attrs))
+	for tag := range attrs {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+	var body []byte
+	for _, tag := range tags {
+		body = append(body, encode_leb128(int64(tag), false)...)
+		if tag%2 == 1 {
+			body = append(append(body, attrs[tag].str...), 0)
+		} else {
+			body = append(body, encode_leb128(int64(attrs[tag].num), false)...)
+		}
+	}
+	size := make([]byte, 4)
+	order.PutUint32(size, uint32(1+4+len(body)))
+	file := append(append([]byte{1}, size...), body...) //Tag_File
+	vendor := []byte("riscv\x00")
+	order.PutUint32(size, uint32(4+len(vendor)+len(file)))
+	return append(append(append([]byte{'A'}, size...), vendor...), file...)
+}
+
+// EF_RISCV_RVE: the code only uses x0..x15
+const efRISCVRVE = 0x8
+
+// e_flags: no float ABI yet, RVE for rv32e
+func elf_header_flags() uint32 {
+	flags := uint32(0)
+	if activeISA.base == 'e' {
+		flags |= efRISCVRVE
+	}
+	return flags
+}
@@ -0,0 +1,75 @@
+package assembler
+
+// field packing for the formats relaxation and the floating point encoder emit on their own. Immediates must
+// already be range checked
+
+func pack_i(opcode uint8, funct3 uint8, rd uint8, rs1 uint8, imm int64) ilen {
+	instruction := ilen(opcode)
+	instruction |= ilen(rd) << 7
+	instruction |= ilen(funct3) << 12
+	instruction |= ilen(rs1) << 15       //000000001010 01100 000 01000 1100111
+	instruction |= ilen(imm&0xFFF) << 20 //010001010110 01011 000 00110 0010011
+	return instruction
+}
+
+func pack_s(opcode uint8, funct3 uint8, rs1 uint8, rs2 uint8, imm int64) ilen {
+	instruction := ilen(opcode)
+	instruction |= ilen(imm&0x1F) << 7 // imm[4:0]
+	instruction |= ilen(funct3) << 12
+	instruction |= ilen(rs1) << 15
+	instruction |= ilen(rs2) << 20
+	instruction |= ilen((imm>>5)&0x7F) << 25 // imm[11:5]
+	return instruction
+}
+
+func pack_b(opcode uint8, funct3 uint8, rs1 uint8, rs2 uint8, offset int64) ilen {
+	immediate := uint32(offset)
+	//imm[0] can be dropped because all instructions are byte aligned
+	imm_4_1 := (immediate & 0b0000000011110) >> 1
+	imm_5_10 := (immediate & 0b0011111100000) >> 5
+	imm_11 := (immediate & 0b0100000000000) >> 11
+	imm_12 := (immediate & 0b1000000000000) >> 12
+	//fmt.Printf("imm: %013b(%d) -> %01b %04b %06b %01b\n", uint16(immediate), int16(immediate), imm_11, imm_4_1, imm_5_10, imm_12)
+
+	instruction := ilen(opcode)
+	instruction |= ilen(imm_11) << 7
+	instruction |= ilen(imm_4_1) << 8
+	instruction |= ilen(funct3) << 12
+	instruction |= ilen(rs1) << 15
+	instruction |= ilen(rs2) << 20
+	instruction |= ilen(imm_5_10) << 25 //0000000 01100 00101 001 1000 0 1100011
+	instruction |= ilen(imm_12) << 31
+	return instruction
+}
+
+func pack_u(opcode uint8, rd uint8, imm20 int64) ilen {
+	instruction := ilen(opcode)
+	instruction |= ilen(rd) << 7
+	instruction |= ilen(imm20&0xFFFFF) << 12
+	return instruction
+}
+
+func pack_j(opcode uint8, rd uint8, offset int64) ilen {
+	immediate := uint32(offset)
+	//split immediate into J format
+	imm_12_19 := (immediate >> 12) & 0xFF
+	imm_11 := (immediate >> 11) & 0x1
+	imm_1_10 := (immediate >> 1) & 0x3FF
+	imm_20 := (immediate >> 20) & 0x1
+	//fmt.Printf("imm: %032b(%d) %08b %01b %010b %01b\n", immediate, immediate, imm_12_19, imm_11, imm_1_10, imm_20)
+
+	instruction := ilen(opcode)
+	instruction |= ilen(rd) << 7
+	instruction |= ilen(imm_12_19) << 12
+	instruction |= ilen(imm_11) << 20
+	instruction |= ilen(imm_1_10) << 21
+	instruction |= ilen(imm_20) << 31 //0 0111010101 1 00001010 00110 1101111
+	return instruction
+}
+
+// splits a pc relative offset into the auipc upper part and the signed low 12 bits added by jalr/addi
+func split_hi_lo(offset int64) (int64, int64) {
+	hi := (offset + 0x800) >> 12
+	lo := offset - hi<<12
+	return hi & 0xFFFFF, lo
+}
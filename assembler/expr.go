@@ -0,0 +1,258 @@
+package assembler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// expression operands: numbers, 'c' chars, .equ names, labels (incl. 1b/1f), . for the current address,
+// parentheses, %hi()/%lo() and the C operators | ^ & << >> + - * / % with unary - ~ !
+type expr_parser struct {
+	src      string
+	pos      int
+	curr_idx int
+	is_addr  bool //a label or . was used
+}
+
+// evaluates an assembler expression for the line at curr_idx
+func eval_expr(src string, curr_idx int) (int64, error) {
+	p := &expr_parser{src: src, curr_idx: curr_idx}
+	val, err := p.parse_binary(0)
+	if err != nil {
+		return 0, err
+	}
+	p.skip_space()
+	if p.pos != len(p.src) {
+		return 0, fmt.Errorf("unexpected %q in expression %q", p.src[p.pos:], src)
+	}
+	return val, nil
+}
+
+// binary operator precedence, loosest first
+var exprPrecedence = [][]string{
+	{"|"},
+	{"^"},
+	{"&"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *expr_parser) skip_space() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *expr_parser) parse_binary(level int) (int64, error) {
+	if level == len(exprPrecedence) {
+		return p.parse_unary()
+	}
+	lhs, err := p.parse_binary(level + 1)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		p.skip_space()
+		op := ""
+		for _, cand := range exprPrecedence[level] {
+			if strings.HasPrefix(p.src[p.pos:], cand) {
+				op = cand
+				break
+			}
+		}
+		if op == "" {
+			return lhs, nil
+		}
+		p.pos += len(op)
+		rhs, err := p.parse_binary(level + 1)
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case "|":
+			lhs |= rhs
+		case "^":
+			lhs ^= rhs
+		case "&":
+			lhs &= rhs
+		case "<<":
+			lhs <<= uint64(rhs) & 63
+		case ">>":
+			lhs >>= uint64(rhs) & 63
+		case "+":
+			lhs += rhs
+		case "-":
+			lhs -= rhs
+		case "*":
+			lhs *= rhs
+		case "/", "%":
+			if rhs == 0 {
+				return 0, fmt.Errorf("division by zero in expression %q", p.src)
+			}
+			if op == "/" {
+				lhs /= rhs
+			} else {
+				lhs %= rhs
+			}
+		}
+	}
+}
+
+func (p *expr_parser) parse_unary() (int64, error) {
+	p.skip_space()
+	if p.pos >= len(p.src) {
+		return 0, fmt.Errorf("expression %q ends early", p.src)
+	}
+	switch p.src[p.pos] {
+	case '-':
+		p.pos++
+		val, err := p.parse_unary()
+		return -val, err
+	case '+':
+		p.pos++
+		return p.parse_unary()
+	case '~':
+		p.pos++
+		val, err := p.parse_unary()
+		return ^val, err
+	case '!':
+		p.pos++
+		val, err := p.parse_unary()
+		if val == 0 {
+			return 1, err
+		}
+		return 0, err
+	}
+	return p.parse_primary()
+}
+
+func (p *expr_parser) parse_primary() (int64, error) {
+	c := p.src[p.pos]
+	switch {
+	case c == '(':
+		p.pos++
+		val, err := p.parse_binary(0)
+		if err != nil {
+			return 0, err
+		}
+		p.skip_space()
+		if p.pos >= len(p.src) || p.src[p.pos] != ')' {
+			return 0, fmt.Errorf("missing ) in expression %q", p.src)
+		}
+		p.pos++
+		return val, nil
+	case c == '%':
+		// %hi(x) / %lo(x) split an address for lui + addi/load/store
+		p.pos++
+		var part string
+		for _, cand := range []string{"hi(", "lo("} {
+			if strings.HasPrefix(p.src[p.pos:], cand) {
+				part = cand[:2]
+			}
+		}
+		if part == "" {
+			return 0, fmt.Errorf("unknown %% operator in expression %q", p.src)
+		}
+		p.pos += 2
+		was_addr := p.is_addr
+		val, err := p.parse_primary()
+		if err != nil {
+			return 0, err
+		}
+		p.is_addr = was_addr //an address half is a plain number, not a branch target
+		hi, lo := split_hi_lo(val)
+		if part == "hi" {
+			return hi, nil
+		}
+		return lo, nil
+	case c == '\'':
+		// 'c' or '\n'
+		r, _, tail, err := strconv.UnquoteChar(p.src[p.pos+1:], '\'')
+		if err != nil || !strings.HasPrefix(tail, "'") {
+			return 0, fmt.Errorf("invalid character in expression %q", p.src)
+		}
+		p.pos = len(p.src) - len(tail) + 1
+		return int64(r), nil
+	case c >= '0' && c <= '9':
+		start := p.pos
+		for p.pos < len(p.src) && is_ident_char(p.src[p.pos]) {
+			p.pos++
+		}
+		tok := p.src[start:p.pos]
+		if val, err := strconv.ParseInt(tok, 0, 64); err == nil {
+			return val, nil
+		}
+		if val, err := strconv.ParseUint(tok, 0, 64); err == nil {
+			return int64(val), nil
+		}
+		// 1b / 1f
+		if addr, ok := resolve_label(tok, p.curr_idx); ok {
+			p.is_addr = true
+			return int64(addr), nil
+		}
+		return 0, fmt.Errorf("invalid number or undefined local label %q", tok)
+	case c == '.' && (p.pos+1 == len(p.src) || !is_ident_char(p.src[p.pos+1])):
+		p.pos++
+		p.is_addr = true
+		return int64(instr_addresses[p.curr_idx]), nil
+	case is_ident_char(c):
+		start := p.pos
+		for p.pos < len(p.src) && is_ident_char(p.src[p.pos]) {
+			p.pos++
+		}
+		name := p.src[start:p.pos]
+		if _, ok := valueTable[name]; !ok {
+			p.is_addr = true
+		}
+		return lookup_symbol(name, p.curr_idx)
+	}
+	return 0, fmt.Errorf("unexpected %q in expression %q", c, p.src)
+}
+
+// .equ values first, then labels
+func lookup_symbol(name string, curr_idx int) (int64, error) {
+	if val, ok := valueTable[name]; ok {
+		return val, nil
+	}
+	if addr, ok := resolve_label(name, curr_idx); ok {
+		return int64(addr), nil
+	}
+	return 0, fmt.Errorf("undefined symbol %q", name)
+}
+
+func is_ident_char(c byte) bool {
+	return c == '_' || c == '.' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// splits operands on commas that are not inside quotes or parentheses
+func split_operands(s string) []string {
+	var out []string
+	depth, start := 0, 0
+	in_quote := byte(0)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case in_quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == in_quote {
+				in_quote = 0
+			}
+		case c == '"' || c == '\'':
+			in_quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			out = append(out, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if tail := strings.TrimSpace(s[start:]); tail != "" || len(out) > 0 {
+		out = append(out, tail)
+	}
+	return out
+}
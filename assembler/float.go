@@ -0,0 +1,152 @@
+package assembler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Floating point instructions mix integer and floating point registers in the usual rd/rs1/rs2 positions, so
+// their fields say which kind (frd vs rd). Operands are written destination first, then the sources, then an
+// optional rounding mode; loads and stores take an imm(rs1) address like lw/sw
+
+// fields only floating point instructions have
+var floatFields = map[string]bool{"frd": true, "frs1": true, "frs2": true, "frs3": true, "rm": true}
+
+// rounding mode operand names, by their rm encoding. 5 and 6 are reserved
+var roundingModes = map[string]uint32{"rne": 0, "rtz": 1, "rdn": 2, "rup": 3, "rmm": 4, "dyn": 7}
+
+// conversions that are always exact, where rm does nothing and defaults to rne instead of dyn
+var exactConversions = map[string]bool{
+	"fcvt.d.s": true, "fcvt.d.w": true, "fcvt.d.wu": true, "fcvt.s.h": true, "fcvt.d.h": true, "fcvt.s.bf16": true,
+}
+
+func (desc InstrDesc) is_float() bool {
+	for _, arg := range desc.args {
+		if floatFields[arg] {
+			return true
+		}
+	}
+	return false
+}
+
+func freg_operand(name string) (uint8, error) {
+	reg, ok := fregMap[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown floating point register %q", name)
+	}
+	return reg, nil
+}
+
+func freg_name(r uint32) string {
+	return fabiNames[r&0x1F]
+}
+
+// the fields of a floating point instruction in the order they are written, without rm
+func float_operands(desc InstrDesc) []string {
+	switch {
+	case desc.has_arg("imm12hi"): //fsh frs2, imm(rs1)
+		return []string{"frs2", "mem"}
+	case desc.has_arg("imm12"): //flh frd, imm(rs1)
+		return []string{"frd", "mem"}
+	}
+	var order []string
+	for _, field := range []string{"rd", "frd", "frs1", "rs1", "frs2", "frs3"} {
+		if desc.has_arg(field) {
+			order = append(order, field)
+		}
+	}
+	return order
+}
+
+func default_rounding_mode(mnemonic string) uint32 {
+	if exactConversions[mnemonic] {
+		return roundingModes["rne"]
+	}
+	return roundingModes["dyn"]
+}
+
+func encode_float(curr_idx int, mnemonic string, itype InstrDesc, operands []string) (ilen, error) {
+	fields := float_operands(itype)
+	rm := default_rounding_mode(mnemonic)
+	if itype.has_arg("rm") && len(operands) == len(fields)+1 {
+		mode, ok := roundingModes[operands[len(operands)-1]]
+		if !ok {
+			return 0, fmt.Errorf("%s: unknown rounding mode %q", mnemonic, operands[len(operands)-1])
+		}
+		rm, operands = mode, operands[:len(operands)-1]
+	}
+	if err := operand_count(mnemonic, operands, len(fields)); err != nil {
+		return 0, err
+	}
+	word := itype.match
+	if itype.has_arg("rm") {
+		word |= rm << 12
+	}
+	for i, field := range fields {
+		var reg uint8
+		var err error
+		switch field {
+		case "rd", "rs1":
+			reg, err = reg_operand(operands[i])
+		case "frd", "frs1", "frs2", "frs3":
+			reg, err = freg_operand(operands[i])
+		case "mem":
+			var offset int64
+			offset, reg, err = mem_operand(operands[i], curr_idx)
+			if err != nil {
+				return 0, fmt.Errorf("%s: %w", mnemonic, err)
+			}
+			if err := check_imm(mnemonic, offset, iRange); err != nil {
+				return 0, err
+			}
+			if itype.has_arg("imm12hi") {
+				word |= uint32(pack_s(0, 0, 0, 0, offset))
+			} else {
+				word |= uint32(pack_i(0, 0, 0, 0, offset))
+			}
+			field = "rs1"
+		}
+		if err != nil {
+			return 0, err
+		}
+		f := operandFields[field]
+		word |= uint32(reg) << f.lo
+	}
+	return ilen(word), nil
+}
+
+// text of a floating point instruction, in the order encode_float takes it. rm is only shown when it is not
+// the default
+func decode_float(name string, desc InstrDesc, word uint32) string {
+	var ops []string
+	for _, field := range float_operands(desc) {
+		lookup := field
+		if field == "mem" {
+			lookup = "rs1"
+		}
+		f := operandFields[lookup]
+		val := (word & field_mask(f)) >> f.lo
+		switch field {
+		case "rd", "rs1":
+			ops = append(ops, reg_name(val))
+		case "frd", "frs1", "frs2", "frs3":
+			ops = append(ops, freg_name(val))
+		case "mem":
+			offset := int64(int32(word) >> 20)
+			if desc.has_arg("imm12hi") {
+				offset = int64(int32(word)>>25)<<5 | int64((word>>7)&0x1F)
+			}
+			ops = append(ops, fmt.Sprintf("%d(%s)", offset, reg_name(val)))
+		}
+	}
+	if rm := (word >> 12) & 0x7; desc.has_arg("rm") && rm != default_rounding_mode(name) {
+		mode := fmt.Sprintf("%d", rm)
+		for m, enc := range roundingModes {
+			if enc == rm {
+				mode = m
+			}
+		}
+		ops = append(ops, mode)
+	}
+	return name + " " + strings.Join(ops, ", ")
+}
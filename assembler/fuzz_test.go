@@ -0,0 +1,70 @@
+package assembler
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// seeds: the example program and every golden case
+func add_seeds(f *testing.F) {
+	if src, err := os.ReadFile("../assembly/asm_example.s"); err == nil {
+		f.Add(string(src))
+	}
+	for _, c := range read_golden(f, "testdata/golden.txt") {
+		f.Add(strings.Join(c.src, "\n"))
+	}
+	f.Add(".rept 3\n.byte 1\n.endr")
+	f.Add(".rept 0x7fffffffffffffff\n.byte 1\n.endr")
+	f.Add(".rept 0x4000000000000000\n.byte 1\n.byte 2\n.byte 3\n.byte 4\n.endr")
+	f.Add(".irp r, a0, a1\naddi \\r, \\r, 1\n.endr")
+	f.Add("call f\nla a0, x\nf:\nlui a1, %hi(x)\nlw a1, %lo(x)(a1)\n.data\nx: .word 1")
+}
+
+// preprocessing (comments, .rept/.irp) must fail with an error, never a panic
+func FuzzParseSource(f *testing.F) {
+	add_seeds(f)
+	f.Fuzz(func(t *testing.T, src string) {
+		ParseSource(src)
+	})
+}
+
+func FuzzEvalExpr(f *testing.F) {
+	for _, seed := range []string{"1 + 2 * 3", "(x - .) >> 2", "'a' | ~0", "%hi(x) + %lo(x)", "1b", "-(-8 % 3)", "4 / 0"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, expr string) {
+		if _, err := Assemble([]string{"x:", ".equ y, 4", "1:"}); err != nil {
+			t.Fatal(err)
+		}
+		eval_expr(expr, 1)
+	})
+}
+
+// Assemble is also handed lines that never went through ParseSource
+func FuzzAssembleLine(f *testing.F) {
+	for _, c := range read_golden(f, "testdata/golden.txt") {
+		f.Add(c.src[len(c.src)-1])
+	}
+	f.Add("")
+	f.Add("\taddi a0,a0,1")
+	f.Add("add ")
+	f.Fuzz(func(t *testing.T, line string) {
+		Assemble([]string{line})
+	})
+}
+
+// whatever the source, the pipeline ends in an image or a diagnostic
+func FuzzAssemble(f *testing.F) {
+	add_seeds(f)
+	f.Fuzz(func(t *testing.T, src string) {
+		lines, err := ParseSource(src)
+		if err != nil {
+			return
+		}
+		image, err := Assemble(lines)
+		if err == nil {
+			Disassemble(image, 0)
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package assembler
+
+import (
+	"bufio"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+)
+
+type golden_case struct {
+	line  int
+	src   []string
+	bytes string
+}
+
+// testdata/golden.txt: "line; line => hex bytes", # comments
+func read_golden(t testing.TB, filename string) []golden_case {
+	t.Helper()
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var cases []golden_case
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		src, want, ok := strings.Cut(text, "=>")
+		if !ok {
+			t.Fatalf("%s:%d: missing =>", filename, n)
+		}
+		c := golden_case{line: n, bytes: strings.ReplaceAll(strings.TrimSpace(want), " ", "")}
+		for _, line := range strings.Split(src, ";") {
+			c.src = append(c.src, strings.TrimSpace(line))
+		}
+		cases = append(cases, c)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return cases
+}
+
+func TestGolden(t *testing.T) {
+	enable_all_exts(t)
+	for _, c := range read_golden(t, "testdata/golden.txt") {
+		image, err := Assemble(c.src)
+		if err != nil {
+			t.Errorf("golden.txt:%d: %s", c.line, err)
+			continue
+		}
+		got := hex.EncodeToString(image)
+		if got != c.bytes {
+			t.Errorf("golden.txt:%d: %q\n got %s\nwant %s", c.line, strings.Join(c.src, "; "), got, c.bytes)
+		}
+	}
+}
+
+// the corpus has to grow with InstrTable
+func TestGoldenCoversInstrTable(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, c := range read_golden(t, "testdata/golden.txt") {
+		for _, line := range c.src {
+			seen[strings.SplitN(line, " ", 2)[0]] = true
+		}
+	}
+	for name := range InstrTable {
+		if !seen[name] {
+			t.Errorf("no golden encoding for %s", name)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package assembler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Immediate policy: an immediate is a signed value and is stored in two's complement, so -1 in an I-type
+// instruction becomes 0xFFF. Values are checked against their field's range before encoding and are never
+// silently truncated. A label operand of a branch or jump is turned into an offset from the instruction;
+// a plain number (or .equ value) is already that offset.
+type imm_range struct {
+	desc     string
+	min, max int64
+	even     bool //offset of an instruction, imm[0] is not encoded
+}
+
+var (
+	iRange     = imm_range{"signed 12-bit", -2048, 2047, false}
+	sRange     = imm_range{"signed 12-bit", -2048, 2047, false}
+	shamtRange = imm_range{"5-bit shift amount", 0, 31, false}
+	bRange     = imm_range{"signed 13-bit branch offset", -4096, 4094, true}
+	jRange     = imm_range{"signed 21-bit jump offset", -1048576, 1048574, true}
+	uRange     = imm_range{"20-bit upper immediate", 0, 0xFFFFF, false}
+	vsRange    = imm_range{"signed 5-bit", -16, 15, false}
+	vuRange    = imm_range{"5-bit unsigned", 0, 31, false}
+	bsRange    = imm_range{"byte select", 0, 3, false}
+)
+
+func check_imm(mnemonic string, val int64, r imm_range) error {
+	if val < r.min || val > r.max {
+		return fmt.Errorf("%s: immediate %d (0x%X) is out of range for a %s (%d..%d)", mnemonic, val, val, r.desc, r.min, r.max)
+	}
+	if r.even && val%2 != 0 {
+		return fmt.Errorf("%s: offset %d is odd, instructions are 2-byte aligned", mnemonic, val)
+	}
+	return nil
+}
+
+func operand_count(mnemonic string, operands []string, want int) error {
+	if len(operands) != want {
+		return fmt.Errorf("%s takes %d operands, got %d", mnemonic, want, len(operands))
+	}
+	for _, op := range operands {
+		if op == "" {
+			return fmt.Errorf("%s has an empty operand", mnemonic)
+		}
+	}
+	return nil
+}
+
+func reg_operand(name string) (uint8, error) {
+	reg, ok := regMap[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown register %q", name)
+	}
+	if activeISA.base == 'e' && reg >= 16 {
+		return 0, fmt.Errorf("register %s (x%d) does not exist in %s, which only has x0..x15", name, reg, activeISA.src)
+	}
+	return reg, nil
+}
+
+// value of an immediate expression and whether it came from an address (label, 1b/1f or .)
+func imm_operand(op string, curr_idx int) (int64, bool, error) {
+	p := &expr_parser{src: op, curr_idx: curr_idx}
+	val, err := p.parse_binary(0)
+	if err != nil {
+		return 0, false, err
+	}
+	p.skip_space()
+	if p.pos != len(p.src) {
+		return 0, false, fmt.Errorf("unexpected %q in expression %q", p.src[p.pos:], op)
+	}
+	return val, p.is_addr, nil
+}
+
+// branch/jump target as an offset from the instruction at curr_idx
+func pc_offset(op string, curr_idx int) (int64, error) {
+	val, is_addr, err := imm_operand(op, curr_idx)
+	if err != nil {
+		return 0, err
+	}
+	if is_addr {
+		return val - int64(instr_addresses[curr_idx]), nil
+	}
+	return val, nil
+}
+
+// offset(reg) operand of loads, stores and jalr. The offset may be left out: (reg)
+func mem_operand(op string, curr_idx int) (int64, uint8, error) {
+	open := strings.LastIndex(op, "(")
+	if open < 0 || !strings.HasSuffix(op, ")") {
+		return 0, 0, fmt.Errorf("invalid format %q, expected imm(reg)", op)
+	}
+	rs1, err := reg_operand(strings.TrimSpace(op[open+1 : len(op)-1]))
+	if err != nil {
+		return 0, 0, err
+	}
+	offset := strings.TrimSpace(op[:open])
+	if offset == "" {
+		return 0, rs1, nil
+	}
+	val, _, err := imm_operand(offset, curr_idx)
+	return val, rs1, err
+}
@@ -0,0 +1,69 @@
+package assembler
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// the ends of every range, checked against llvm-mc. Negative values are two's complement
+func TestImmediateRanges(t *testing.T) {
+	cases := []struct {
+		line string
+		word uint32
+	}{
+		{"addi a0, a1, -2048", 0x80058513},
+		{"addi a0, a1, 2047", 0x7ff58513},
+		{"addi a0, a1, -1", 0xfff58513},
+		{"addi t1, a1, 1110", 0x45658313},
+		{"andi a0, a1, 0x7ff", 0x7ff5f513},
+		{"lw a0, -2048(sp)", 0x80012503},
+		{"lw a0, 2047(sp)", 0x7ff12503},
+		{"sw a0, -1(sp)", 0xfea12fa3},
+		{"sw a0, 2047(sp)", 0x7ea12fa3},
+		{"sw a0, -2048(sp)", 0x80a12023},
+		{"slli a0, a1, 31", 0x01f59513},
+		{"srai a0, a1, 31", 0x41f5d513},
+		{"beq a0, a1, 4094", 0x7eb50fe3},
+		{"beq a0, a1, -4096", 0x80b50063},
+		{"bne a0, a1, -2", 0xfeb51fe3},
+		{"jal ra, 1048574", 0x7ffff0ef},
+		{"jal ra, -1048576", 0x800000ef},
+		{"lui a0, 0xfffff", 0xfffff537},
+		{"auipc a0, 0", 0x00000517},
+		{"jalr ra, -1(a0)", 0xfff500e7},
+	}
+	for _, c := range cases {
+		if got := binary.LittleEndian.Uint32(assemble_image(t, []string{c.line})); got != c.word {
+			t.Errorf("%q = %08x, want %08x", c.line, got, c.word)
+		}
+	}
+}
+
+func TestImmediateRejects(t *testing.T) {
+	for line, msg := range map[string]string{
+		"addi a0, a1, 2048":        "out of range",
+		"addi a0, a1, -2049":       "out of range",
+		"addi a0, a1, 0xfff":       "out of range",
+		"lw a0, 2048(sp)":          "out of range",
+		"sw a0, -2049(sp)":         "out of range",
+		"jalr ra, 4096(a0)":        "out of range",
+		"slli a0, a1, 32":          "shift amount",
+		"srli a0, a1, -1":          "shift amount",
+		"beq a0, a1, 4096":         "branch offset",
+		"beq a0, a1, -4098":        "branch offset",
+		"beq a0, a1, 3":            "odd",
+		"jal ra, 1048576":          "jump offset",
+		"jal ra, -1048578":         "jump offset",
+		"jal ra, 5":                "odd",
+		"lui a0, 0x100000":         "upper immediate",
+		"lui a0, -1":               "upper immediate",
+		"auipc a0, 0x100000":       "upper immediate",
+		"addi a0, a1, 1 +":         "",
+		"addi a0, a1, 99999999999": "out of range",
+	} {
+		if _, err := Assemble([]string{line}); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%q: %v, want an error about %q", line, err, msg)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package assembler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// .insn lets a program emit instructions InstrTable does not know, e.g. for custom-0..3 opcodes:
+//
+//	.insn r opcode, funct3, funct7, rd, rs1, rs2
+//	.insn i opcode, funct3, rd, rs1, imm   (or rd, imm(rs1))
+//	.insn s opcode, funct3, rs2, imm(rs1)
+//	.insn b opcode, funct3, rs1, rs2, label (also sb)
+//	.insn u opcode, rd, imm
+//	.insn j opcode, rd, label               (also uj)
+//	.insn value / .insn 4, value
+//
+// The fields become a one-off InstrDesc that goes through the same packing as every other instruction
+type insn_format struct {
+	fmt    InstrFmt
+	functs int //funct3 and funct7 given after the opcode
+	args   []string
+}
+
+var insnFormats = map[string]insn_format{
+	"r":  {R, 2, []string{"rd", "rs1", "rs2"}},
+	"i":  {I, 1, []string{"rd", "rs1", "imm12"}},
+	"s":  {S, 1, []string{"imm12hi", "rs1", "rs2", "imm12lo"}},
+	"b":  {B, 1, []string{"bimm12hi", "rs1", "rs2", "bimm12lo"}},
+	"sb": {B, 1, []string{"bimm12hi", "rs1", "rs2", "bimm12lo"}},
+	"u":  {U, 0, []string{"rd", "imm20"}},
+	"j":  {J, 0, []string{"rd", "jimm20"}},
+	"uj": {J, 0, []string{"rd", "jimm20"}},
+}
+
+// major opcode names usable instead of a number, as in GNU as
+var insnOpcodes = map[string]int64{
+	"LOAD": 0x03, "LOAD_FP": 0x07, "CUSTOM_0": 0x0b, "MISC_MEM": 0x0f, "OP_IMM": 0x13, "AUIPC": 0x17,
+	"OP_IMM_32": 0x1b, "STORE": 0x23, "STORE_FP": 0x27, "CUSTOM_1": 0x2b, "AMO": 0x2f, "OP": 0x33,
+	"LUI": 0x37, "OP_32": 0x3b, "MADD": 0x43, "MSUB": 0x47, "NMSUB": 0x4b, "NMADD": 0x4f, "OP_FP": 0x53,
+	"OP_V": 0x57, "CUSTOM_2": 0x5b, "BRANCH": 0x63, "JALR": 0x67, "JAL": 0x6f, "SYSTEM": 0x73, "CUSTOM_3": 0x7b,
+}
+
+// splits .insn into its format letter (empty for a raw value) and the operands after it
+func insn_args(op_split []string) (string, []string, error) {
+	if len(op_split) < 2 || strings.TrimSpace(op_split[1]) == "" {
+		return "", nil, fmt.Errorf(".insn needs a format or a value")
+	}
+	kind, rest, _ := strings.Cut(strings.TrimSpace(op_split[1]), " ")
+	if _, ok := insnFormats[strings.ToLower(kind)]; ok {
+		return strings.ToLower(kind), split_operands(rest), nil
+	}
+	return "", split_operands(op_split[1]), nil
+}
+
+// first pass: every form is one 32-bit instruction, which a raw .insn 2, ... would not be
+func insn_size(op_split []string, curr_idx int) (ilen, error) {
+	kind, args, err := insn_args(op_split)
+	if err != nil {
+		return 0, err
+	}
+	if kind == "" && len(args) == 2 {
+		length, err := eval_expr(args[0], curr_idx)
+		if err != nil {
+			return 0, fmt.Errorf(".insn length: %w", err)
+		}
+		if length != int64(ILEN_BYTES) {
+			return 0, fmt.Errorf(".insn length %d: only %d byte instructions are supported", length, ILEN_BYTES)
+		}
+	}
+	return ILEN_BYTES, nil
+}
+
+// value of an opcode/funct operand, which has to fit in bits bits
+func insn_field(op string, bits uint, curr_idx int) (int64, error) {
+	if val, ok := insnOpcodes[strings.ToUpper(op)]; ok && bits == 7 {
+		return val, nil
+	}
+	val, err := eval_expr(op, curr_idx)
+	if err != nil {
+		return 0, err
+	}
+	if val < 0 || val >= 1<<bits {
+		return 0, fmt.Errorf("%s does not fit in %d bits", op, bits)
+	}
+	return val, nil
+}
+
+func emit_insn(curr_idx int, bin_arr []byte, op_split []string) error {
+	kind, args, err := insn_args(op_split)
+	if err != nil {
+		return err
+	}
+	if kind == "" {
+		if len(args) != 1 && len(args) != 2 {
+			return fmt.Errorf(".insn takes a format and its fields, or [length,] value")
+		}
+		val, err := eval_expr(args[len(args)-1], curr_idx)
+		if err != nil {
+			return fmt.Errorf(".insn: %w", err)
+		}
+		if val < 0 || val > 0xFFFFFFFF || val&0x3 != 0x3 || val&0x1C == 0x1C {
+			return fmt.Errorf(".insn 0x%X is not a 32-bit instruction", val)
+		}
+		populate_bin_instruction(ilen(val), instr_addresses[curr_idx], bin_arr)
+		return nil
+	}
+
+	form := insnFormats[kind]
+	mnemonic := ".insn " + kind
+	if len(args) <= form.functs {
+		return fmt.Errorf("%s needs an opcode and %d funct fields", mnemonic, form.functs)
+	}
+	opcode, err := insn_field(args[0], 7, curr_idx)
+	if err != nil {
+		return fmt.Errorf("%s opcode: %w", mnemonic, err)
+	}
+	if opcode&0x3 != 0x3 {
+		return fmt.Errorf("%s opcode 0x%X is not a 32-bit opcode (low bits must be 11)", mnemonic, opcode)
+	}
+	desc := InstrDesc{fmt: form.fmt, Opcode: uint8(opcode), args: form.args}
+	if form.functs >= 1 {
+		funct3, err := insn_field(args[1], 3, curr_idx)
+		if err != nil {
+			return fmt.Errorf("%s funct3: %w", mnemonic, err)
+		}
+		desc.funct3 = uint8(funct3)
+	}
+	if form.functs >= 2 {
+		funct7, err := insn_field(args[2], 7, curr_idx)
+		if err != nil {
+			return fmt.Errorf("%s funct7: %w", mnemonic, err)
+		}
+		desc.funct7 = uint8(funct7)
+	}
+	desc.match = uint32(desc.Opcode)
+	if form.fmt != U && form.fmt != J {
+		desc.match |= uint32(desc.funct3) << 12
+	}
+	desc.match |= uint32(desc.funct7) << 25
+	return encode_instruction(curr_idx, bin_arr, mnemonic, desc, args[1+form.functs:])
+}
@@ -0,0 +1,246 @@
+package assembler
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// --isa-ext files describe a custom extension in a small YAML subset:
+//
+//	extension: xmyext
+//	instructions:
+//	  - name: mac
+//	    syntax: rd, rs1, rs2
+//	    encoding: rd rs1 rs2 31..25=1 14..12=0 6..0=0x0b
+//
+// encoding is a line in the opcodes/ format, syntax has to be one the operand fields allow (see syntaxForms).
+// The instructions go into InstrTable like the built-in ones but only count once -march names the extension
+
+// custom extensions loaded so far
+var customExts = map[string]bool{}
+
+var customExtName = regexp.MustCompile(`^x[a-z][a-z0-9]*$`)
+
+// operand syntaxes per set of operand fields (sorted). The first is the default when a file gives none
+var syntaxForms = map[string][]string{
+	"":                          {""},
+	"rd rs1 rs2":                {"rd, rs1, rs2"},
+	"rd rs1":                    {"rd, rs1"},
+	"imm12 rd rs1":              {"rd, rs1, imm", "rd, imm(rs1)"},
+	"rd rs1 shamtw":             {"rd, rs1, shamt"},
+	"imm12hi imm12lo rs1 rs2":   {"rs2, imm(rs1)"},
+	"bimm12hi bimm12lo rs1 rs2": {"rs1, rs2, label"},
+	"imm20 rd":                  {"rd, imm"},
+	"jimm20 rd":                 {"rd, label"},
+}
+
+// one "- key: value ..." item of a YAML list
+type yaml_item struct {
+	line   int
+	fields map[string]string
+}
+
+// top level scalars and lists of a YAML document, enough for --isa-ext files
+type yaml_doc struct {
+	fields map[string]string
+	lists  map[string][]yaml_item
+}
+
+func yaml_scalar(val string) (string, error) {
+	val = strings.TrimSpace(val)
+	if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') {
+		if val[len(val)-1] != val[0] {
+			return "", fmt.Errorf("unterminated string %s", val)
+		}
+		if val[0] == '\'' {
+			return strings.ReplaceAll(val[1:len(val)-1], "''", "'"), nil
+		}
+		return strconv.Unquote(val)
+	}
+	if idx := strings.Index(val, " #"); idx != -1 {
+		val = strings.TrimSpace(val[:idx])
+	}
+	return val, nil
+}
+
+func yaml_key(line string, n int) (string, string, error) {
+	key, val, ok := strings.Cut(line, ":")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" || strings.ContainsAny(key, " \t") {
+		return "", "", fmt.Errorf("line %d: expected key: value", n)
+	}
+	val, err := yaml_scalar(val)
+	if err != nil {
+		return "", "", fmt.Errorf("line %d: %w", n, err)
+	}
+	return key, val, nil
+}
+
+// block mappings, one level of "- " lists under a key, comments and quoted scalars. Nothing else
+func parse_yaml(src string) (yaml_doc, error) {
+	doc := yaml_doc{fields: map[string]string{}, lists: map[string][]yaml_item{}}
+	list := "" //key of the list being read
+	var item *yaml_item
+	item_indent := -1
+	for n, line := range strings.Split(src, "\n") {
+		n++
+		line = strings.TrimRight(line, " \t\r")
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || trimmed[0] == '#' || line == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return doc, fmt.Errorf("line %d: tabs are not allowed for indentation", n)
+		}
+		indent := len(line) - len(trimmed)
+		if indent == 0 {
+			key, val, err := yaml_key(trimmed, n)
+			if err != nil {
+				return doc, err
+			}
+			if _, dup := doc.fields[key]; dup {
+				return doc, fmt.Errorf("line %d: %s given twice", n, key)
+			}
+			if _, dup := doc.lists[key]; dup {
+				return doc, fmt.Errorf("line %d: %s given twice", n, key)
+			}
+			list, item = "", nil
+			if val == "" {
+				list = key
+				doc.lists[key] = nil
+				continue
+			}
+			doc.fields[key] = val
+			continue
+		}
+		if list == "" {
+			return doc, fmt.Errorf("line %d: unexpected indentation", n)
+		}
+		if rest, ok := strings.CutPrefix(trimmed, "- "); ok || trimmed == "-" {
+			doc.lists[list] = append(doc.lists[list], yaml_item{line: n, fields: map[string]string{}})
+			item = &doc.lists[list][len(doc.lists[list])-1]
+			item_indent = indent + 2
+			trimmed = strings.TrimSpace(rest)
+			if trimmed == "" {
+				item_indent = -1 //fields on the following lines set it
+				continue
+			}
+		} else if item == nil {
+			return doc, fmt.Errorf("line %d: expected a \"- \" list item", n)
+		} else if item_indent == -1 {
+			item_indent = indent
+		} else if indent != item_indent {
+			return doc, fmt.Errorf("line %d: inconsistent indentation", n)
+		}
+		key, val, err := yaml_key(trimmed, n)
+		if err != nil {
+			return doc, err
+		}
+		if _, dup := item.fields[key]; dup {
+			return doc, fmt.Errorf("line %d: %s given twice", n, key)
+		}
+		item.fields[key] = val
+	}
+	return doc, nil
+}
+
+// LoadISAExtension registers the instructions of an --isa-ext file
+func LoadISAExtension(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if err := load_isa_ext(string(data)); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	return nil
+}
+
+func load_isa_ext(src string) error {
+	doc, err := parse_yaml(src)
+	if err != nil {
+		return err
+	}
+	for key := range doc.fields {
+		if key != "extension" && key != "description" {
+			return fmt.Errorf("unknown key %q", key)
+		}
+	}
+	for key := range doc.lists {
+		if key != "instructions" {
+			return fmt.Errorf("unknown key %q", key)
+		}
+	}
+	ext := strings.ToLower(doc.fields["extension"])
+	if !customExtName.MatchString(ext) {
+		return fmt.Errorf("extension %q: custom extension names are x followed by letters and digits", ext)
+	}
+	if customExts[ext] {
+		return fmt.Errorf("extension %s is already loaded", ext)
+	}
+	if len(doc.lists["instructions"]) == 0 {
+		return fmt.Errorf("extension %s has no instructions", ext)
+	}
+	descs := map[string]InstrDesc{}
+	for _, item := range doc.lists["instructions"] {
+		name, desc, err := custom_instruction(item, ext)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", item.line, err)
+		}
+		if _, dup := InstrTable[name]; dup {
+			return fmt.Errorf("line %d: %s is already an instruction", item.line, name)
+		}
+		if _, dup := descs[name]; dup {
+			return fmt.Errorf("line %d: %s is defined twice", item.line, name)
+		}
+		descs[name] = desc
+	}
+	for name, desc := range descs { //only once the whole file checks out
+		InstrTable[name] = desc
+	}
+	customExts[ext] = true
+	return nil
+}
+
+func custom_instruction(item yaml_item, ext string) (string, InstrDesc, error) {
+	for key := range item.fields {
+		if key != "name" && key != "syntax" && key != "encoding" && key != "description" {
+			return "", InstrDesc{}, fmt.Errorf("unknown key %q", key)
+		}
+	}
+	name := strings.ToLower(item.fields["name"])
+	if name == "" || strings.ContainsAny(name, " \t,:") || strings.HasPrefix(name, ".") {
+		return "", InstrDesc{}, fmt.Errorf("bad instruction name %q", item.fields["name"])
+	}
+	desc, err := parse_opcode(strings.Fields(item.fields["encoding"]), ExtNone)
+	if err != nil {
+		return "", desc, fmt.Errorf("%s: %w", name, err)
+	}
+	if desc.match&0x3 != 0x3 || desc.match&0x1C == 0x1C || desc.mask&0x1F != 0x1F {
+		return "", desc, fmt.Errorf("%s: bits 4..0 have to be fixed to a 32-bit opcode", name)
+	}
+	args := append([]string(nil), desc.args...)
+	sort.Strings(args)
+	forms, ok := syntaxForms[strings.Join(args, " ")]
+	if !ok {
+		return "", desc, fmt.Errorf("%s: no operand syntax for fields %s", name, strings.Join(desc.args, " "))
+	}
+	syntax := strings.Join(strings.Fields(item.fields["syntax"]), " ")
+	if _, given := item.fields["syntax"]; !given {
+		syntax = forms[0]
+	}
+	found := false
+	for _, form := range forms {
+		found = found || syntax == form
+	}
+	if !found {
+		return "", desc, fmt.Errorf("%s: syntax %q does not match its fields, use %q", name, syntax, strings.Join(forms, "\" or \""))
+	}
+	desc.mem = strings.Contains(syntax, "(") && desc.fmt == I
+	desc.xext = ext
+	return name, desc, nil
+}
@@ -0,0 +1,79 @@
+package assembler
+
+import (
+	"strings"
+	"testing"
+)
+
+// loads testdata/xmyext.yaml and takes it out of InstrTable again when the test is done
+func load_xmyext(t *testing.T) {
+	t.Helper()
+	if err := LoadISAExtension("testdata/xmyext.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for name, desc := range InstrTable {
+			if desc.xext == "xmyext" {
+				delete(InstrTable, name)
+			}
+		}
+		delete(customExts, "xmyext")
+		if err := SetArch("rv32i"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestISAExtension(t *testing.T) {
+	load_xmyext(t)
+	if _, err := Assemble([]string{"mac a0, a1, a2"}); err == nil || !strings.Contains(err.Error(), "xmyext") {
+		t.Fatalf("mac without -march=..._xmyext: %v", err)
+	}
+	if err := SetArch("rv32i_xmyext"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		src  string
+		want uint32
+		text string
+	}{
+		{"mac a0, a1, a2", 0x00C5850B, "mac a0, a1, a2"},
+		{"popc a0, a1", 0x0005950B, "popc a0, a1"},
+		{"addmul a0, a1, -1", 0xFFF5A50B, "addmul a0, a1, -1"},
+		{"ldinc a0, 8(a1)", 0x0085B52B, "ldinc a0, 8(a1)"},
+		{"bcnz a0, a1, -4", 0xFEB54EAB, "bcnz a0, a1, -4"},
+	} {
+		if got := assemble_word(t, tc.src); got != tc.want {
+			t.Errorf("%q = %08x, want %08x", tc.src, got, tc.want)
+		}
+		if got := disassemble_word(t, tc.want); got != tc.text {
+			t.Errorf("%08x disassembles to %q, want %q", tc.want, got, tc.text)
+		}
+	}
+}
+
+func TestISAExtensionRejects(t *testing.T) {
+	for _, src := range []string{
+		"extension: myext\ninstructions:\n  - name: a\n    encoding: rd rs1 rs2 31..25=0 14..12=0 6..0=0x0b\n", // no x prefix
+		"extension: xa\ninstructions:\n  - name: add\n    encoding: rd rs1 rs2 31..25=0 14..12=0 6..0=0x0b\n",  // built-in name
+		"extension: xa\ninstructions:\n  - name: a\n    encoding: rd rs1 rs2 31..25=0 14..12=0\n",              // bits missing
+		"extension: xa\ninstructions:\n  - name: a\n    encoding: rd rs1 rs2 31..25=0 14..12=0 6..0=0x0c\n",    // 16-bit opcode
+		"extension: xa\ninstructions:\n  - name: a\n    syntax: rd, rs2\n    encoding: rd rs1 rs2 31..25=0 14..12=0 6..0=0x0b\n",
+		"extension: xa\ninstructions:\n  - name: a\n    encodin: rd rs1 rs2 31..25=0 14..12=0 6..0=0x0b\n",
+		"extension: xa\ninstructions:\n  - name: a\n   encoding: rd rs1 rs2 31..25=0 14..12=0 6..0=0x0b\n", // indentation
+		"extension: xa\ninstructions:\n",
+	} {
+		if err := load_isa_ext(src); err == nil {
+			t.Errorf("%q was accepted", src)
+		}
+		if customExts["xa"] {
+			t.Fatalf("%q registered xa", src)
+		}
+	}
+	if _, ok := InstrTable["a"]; ok {
+		t.Fatal("a rejected file left instructions behind")
+	}
+	if err := SetArch("rv32i_xnotloaded"); err == nil {
+		t.Error("-march accepted an extension that was never loaded")
+	}
+}
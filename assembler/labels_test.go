@@ -0,0 +1,51 @@
+package assembler
+
+import "testing"
+
+func TestLocalLabels(t *testing.T) {
+	FirstPass([]string{
+		"1:",                 // 0: 0x0
+		"jal zero, 1f",       // 1: 0x0
+		"1:",                 // 2: 0x4
+		"jal zero, 1b",       // 3: 0x4
+		"jal zero, 1f",       // 4: 0x8
+		"1:",                 // 5: 0xc
+		"2:",                 // 6: 0xc
+		"addi zero, zero, 0", // 7: 0xc
+		"10:",                // 8: 0x10
+		"beq a0, a1, 10b",    // 9: 0x10
+	})
+	cases := []struct {
+		ref  string
+		idx  int
+		want ilen
+		ok   bool
+	}{
+		// each reference takes the nearest definition in its direction
+		{"1f", 1, 0x4, true},
+		{"1b", 1, 0x0, true},
+		{"1b", 3, 0x4, true},
+		{"1f", 4, 0xc, true},
+		{"2b", 9, 0xc, true},
+		{"10b", 9, 0x10, true},
+		// a definition on the referencing line itself does not count
+		{"1b", 0, 0, false},
+		// nothing to refer to
+		{"2f", 9, 0, false},
+		{"3b", 9, 0, false},
+	}
+	for _, c := range cases {
+		got, ok := resolve_label(c.ref, c.idx)
+		if got != c.want || ok != c.ok {
+			t.Errorf("%s from line %d = 0x%x, %v, want 0x%x, %v", c.ref, c.idx, got, ok, c.want, c.ok)
+		}
+	}
+	// expressions take them too
+	if val, err := eval_expr("1b + 4", 9); err != nil || val != 0x10 {
+		t.Errorf("1b + 4 = 0x%x, %v, want 0x10", val, err)
+	}
+	// numeric labels stay out of the symbol table
+	if _, ok := symbolTable["1"]; ok {
+		t.Error("local label 1 is in the symbol table")
+	}
+}
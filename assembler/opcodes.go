@@ -0,0 +1,270 @@
+package assembler
+
+import (
+	"embed"
+	"fmt"
+	"math/bits"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Instruction definitions live in opcodes/, one file per extension in the riscv-opcodes format. Each line gives
+// the operand fields an instruction takes and the value of every other bit; InstrTable (used by the encoder)
+// and the decoder's match/mask pairs are both built from them, so a new extension is a new file. An instruction
+// that several extensions provide is defined once and named in the others with $import file::name
+//
+//go:embed opcodes/*
+var opcodeFiles embed.FS
+
+// bit range [hi, lo] of each operand field
+type bit_field struct {
+	hi, lo uint
+}
+
+var operandFields = map[string]bit_field{
+	"rd":       {11, 7},
+	"rs1":      {19, 15},
+	"rs2":      {24, 20},
+	"imm12":    {31, 20},
+	"imm12hi":  {31, 25},
+	"imm12lo":  {11, 7},
+	"bimm12hi": {31, 25},
+	"bimm12lo": {11, 7},
+	"imm20":    {31, 12},
+	"jimm20":   {31, 12},
+	"shamtw":   {24, 20},
+	"bs":       {31, 30},
+	"vd":       {11, 7},
+	"vs3":      {11, 7},
+	"vs1":      {19, 15},
+	"vs2":      {24, 20},
+	"vm":       {25, 25},
+	"simm5":    {19, 15},
+	"zimm5":    {19, 15},
+	"zimm10":   {29, 20},
+	"zimm11":   {30, 20},
+	"frd":      {11, 7},
+	"frs1":     {19, 15},
+	"frs2":     {24, 20},
+	"frs3":     {31, 27},
+	"rm":       {14, 12},
+	"csr":      {31, 20},
+	"zimm":     {19, 15},
+	"aq":       {26, 26},
+	"rl":       {25, 25},
+}
+
+// extension each opcode file belongs to
+var opcodeExts = map[string]InstrExt{
+	"rv_i":        ExtNone,
+	"rv_m":        ExtM,
+	"rv_a":        ExtA,
+	"rv_f":        ExtF,
+	"rv_d":        ExtD,
+	"rv_zifencei": ExtZifencei,
+	"rv_zba":      ExtZba,
+	"rv_zbb":      ExtZbb,
+	"rv_zbc":      ExtZbc,
+	"rv_zbs":      ExtZbs,
+	"rv_v":        ExtV,
+	"rv_zbkb":     ExtZbkb,
+	"rv_zbkc":     ExtZbkc,
+	"rv_zbkx":     ExtZbkx,
+	"rv_zknd":     ExtZknd,
+	"rv_zkne":     ExtZkne,
+	"rv_zknh":     ExtZknh,
+	"rv_zksed":    ExtZksed,
+	"rv_zksh":     ExtZksh,
+	"rv_zicond":   ExtZicond,
+	"rv_zfhmin":   ExtZfhmin,
+	"rv_d_zfhmin": ExtZfhmin,
+	"rv_zfh":      ExtZfh,
+	"rv_zfbfmin":  ExtZfbfmin,
+	"rv_zicsr":    ExtZicsr,
+	"rv_h":        ExtH,
+}
+
+// extensions a file's instructions need besides their own, like riscv-opcodes' rv_d_zfh
+var opcodeNeeds = map[string]InstrExt{
+	"rv_d_zfhmin": ExtD,
+}
+
+func field_mask(f bit_field) uint32 {
+	return uint32((uint64(1)<<(f.hi-f.lo+1) - 1) << f.lo)
+}
+
+// an instruction one extension shares with another: "$import rv_zbb::rol" in rv_zbkb
+type opcode_import struct {
+	where string
+	file  string
+	name  string
+	ext   InstrExt
+}
+
+// reads every embedded opcode file into InstrTable
+func load_opcodes() error {
+	files, err := opcodeFiles.ReadDir("opcodes")
+	if err != nil {
+		return err
+	}
+	var imports []opcode_import
+	for _, file := range files {
+		ext, ok := opcodeExts[file.Name()]
+		if !ok {
+			return fmt.Errorf("opcodes/%s: no extension registered for this file", file.Name())
+		}
+		data, err := opcodeFiles.ReadFile(path.Join("opcodes", file.Name()))
+		if err != nil {
+			return err
+		}
+		for n, line := range strings.Split(string(data), "\n") {
+			if idx := strings.Index(line, "#"); idx != -1 {
+				line = line[:idx]
+			}
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			if fields[0] == "$import" {
+				src, name, ok := strings.Cut(strings.Join(fields[1:], ""), "::")
+				if !ok || len(fields) != 2 {
+					return fmt.Errorf("opcodes/%s:%d: expected $import file::name", file.Name(), n+1)
+				}
+				imports = append(imports, opcode_import{fmt.Sprintf("opcodes/%s:%d", file.Name(), n+1), src, name, ext})
+				continue
+			}
+			desc, err := parse_opcode(fields[1:], ext)
+			if err != nil {
+				return fmt.Errorf("opcodes/%s:%d: %s: %w", file.Name(), n+1, fields[0], err)
+			}
+			desc.needs = opcodeNeeds[file.Name()]
+			if _, dup := InstrTable[fields[0]]; dup {
+				return fmt.Errorf("opcodes/%s:%d: %s is defined twice", file.Name(), n+1, fields[0])
+			}
+			InstrTable[fields[0]] = desc
+		}
+	}
+	// once every file is in, so the order files are read in does not matter
+	for _, imp := range imports {
+		desc, ok := InstrTable[imp.name]
+		if !ok || desc.ext&opcodeExts[imp.file] == 0 {
+			return fmt.Errorf("%s: %s is not defined in opcodes/%s", imp.where, imp.name, imp.file)
+		}
+		desc.ext |= imp.ext
+		InstrTable[imp.name] = desc
+	}
+	return nil
+}
+
+// operand fields and hi..lo=value ranges of one instruction
+func parse_opcode(fields []string, ext InstrExt) (InstrDesc, error) {
+	desc := InstrDesc{ext: ext}
+	covered := uint32(0)
+	claim := func(mask uint32, what string) error {
+		if covered&mask != 0 {
+			return fmt.Errorf("%s overlaps bits already given", what)
+		}
+		covered |= mask
+		return nil
+	}
+	for _, field := range fields {
+		rng, val, is_fixed := strings.Cut(field, "=")
+		if !is_fixed {
+			f, ok := operandFields[field]
+			if !ok {
+				return desc, fmt.Errorf("unknown operand field %q", field)
+			}
+			if err := claim(field_mask(f), field); err != nil {
+				return desc, err
+			}
+			desc.args = append(desc.args, field)
+			continue
+		}
+		var f bit_field
+		hi, lo, is_range := strings.Cut(rng, "..")
+		h, err := strconv.ParseUint(hi, 10, 5)
+		if err != nil {
+			return desc, fmt.Errorf("bad bit range %q", rng)
+		}
+		f.hi, f.lo = uint(h), uint(h)
+		if is_range {
+			l, err := strconv.ParseUint(lo, 10, 5)
+			if err != nil || uint(l) > f.hi {
+				return desc, fmt.Errorf("bad bit range %q", rng)
+			}
+			f.lo = uint(l)
+		}
+		v, err := strconv.ParseUint(val, 0, 32)
+		if err != nil || v >= 1<<(f.hi-f.lo+1) {
+			return desc, fmt.Errorf("value %q does not fit in bits %s", val, rng)
+		}
+		if err := claim(field_mask(f), field); err != nil {
+			return desc, err
+		}
+		desc.match |= uint32(v) << f.lo
+		desc.mask |= field_mask(f)
+	}
+	if covered != 0xFFFFFFFF {
+		return desc, fmt.Errorf("bits %#08x are neither fixed nor an operand", ^covered)
+	}
+	desc.fmt = desc.format()
+	desc.Opcode = uint8(desc.match & 0x7F)
+	desc.funct3 = uint8(desc.match>>12) & 0x7
+	desc.funct7 = uint8(desc.match >> 25)
+	desc.mem = desc.fmt == I && desc.has_arg("imm12") && desc.Opcode != uint8(I) //loads and jalr
+	return desc, nil
+}
+
+// the base format an instruction's operand fields amount to
+func (desc InstrDesc) format() InstrFmt {
+	switch {
+	case desc.is_vector():
+		return V
+	case desc.is_float():
+		return F
+	case desc.is_system():
+		return C
+	case desc.has_arg("aq"):
+		return A
+	case desc.has_arg("jimm20"):
+		return J
+	case desc.has_arg("imm20"):
+		return U
+	case desc.has_arg("bimm12hi"):
+		return B
+	case desc.has_arg("imm12hi"):
+		return S
+	case desc.has_arg("rs2"):
+		return R
+	}
+	return I
+}
+
+func (desc InstrDesc) has_arg(name string) bool {
+	for _, arg := range desc.args {
+		if arg == name {
+			return true
+		}
+	}
+	return false
+}
+
+// enabled mnemonics from most to least fixed bits, so the most specific match wins when decoding
+func decode_order() []string {
+	names := make([]string, 0, len(InstrTable))
+	for name, desc := range InstrTable {
+		if instr_enabled(desc) {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		mi, mj := bits.OnesCount32(InstrTable[names[i]].mask), bits.OnesCount32(InstrTable[names[j]].mask)
+		if mi != mj {
+			return mi > mj
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
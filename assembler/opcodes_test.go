@@ -0,0 +1,31 @@
+package assembler
+
+import (
+	"strings"
+	"testing"
+)
+
+// a typo in an opcode file has to be caught when it is loaded, not show up as a wrong encoding
+func TestParseOpcodeRejects(t *testing.T) {
+	for _, line := range []string{
+		"rd rs1 rs2 31..25=0 14..12=0 6..2=0x0C",            // bits 1..0 missing
+		"rd rs1 rs2 31..25=0 14..12=0 6..2=0x0C 1..0=3 7=1", // overlaps rd
+		"rd rs1 imm12 14..12=8 6..2=0x04 1..0=3",            // value too wide
+		"rd rs1 imm 14..12=0 6..2=0x04 1..0=3",              // unknown operand field
+		"rd rs1 imm12 12..14=0 6..2=0x04 1..0=3",            // backwards range
+	} {
+		if _, err := parse_opcode(strings.Fields(line), ExtNone); err == nil {
+			t.Errorf("%q was accepted", line)
+		}
+	}
+}
+
+func TestParseOpcode(t *testing.T) {
+	desc, err := parse_opcode(strings.Fields("rd rs1 shamtw 31..25=32 14..12=5 6..2=0x04 1..0=3"), ExtNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.fmt != I || desc.match != 0x40005013 || desc.mask != 0xFE00707F {
+		t.Errorf("srai: fmt %#x match %#08x mask %#08x", desc.fmt, desc.match, desc.mask)
+	}
+}
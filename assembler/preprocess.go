@@ -0,0 +1,161 @@
+package assembler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// most lines repetition blocks may expand to, so a typo in a count is an error rather than running out of memory
+const maxExpandedLines = 1 << 20
+
+// expands .rept/.irp/.irpc blocks so FirstPass only ever sees plain lines. Blocks can be nested
+func expand_repetitions(lines []string) ([]string, error) {
+	out := make([]string, 0, len(lines))
+	equs := make(map[string]int64) // .equ values seen so far so .rept can take a named count
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		op_split := strings.SplitN(line, " ", 2)
+		switch op_split[0] {
+		case ".equ":
+			if len(op_split) == 2 {
+				args := strings.SplitN(op_split[1], ",", 2)
+				if len(args) == 2 {
+					if val, err := strconv.ParseInt(strings.TrimSpace(args[1]), 0, 64); err == nil {
+						equs[strings.TrimSpace(args[0])] = val
+					}
+				}
+			}
+			out = append(out, line)
+		case ".rept", ".irp", ".irpc":
+			end, err := find_endr(lines, i)
+			if err != nil {
+				return nil, err
+			}
+			args := ""
+			if len(op_split) == 2 {
+				args = strings.TrimSpace(op_split[1])
+			}
+			body := lines[i+1 : end]
+			var expanded []string
+			switch op_split[0] {
+			case ".rept":
+				expanded, err = expand_rept(args, body, equs)
+			case ".irp":
+				expanded, err = expand_irp(args, body, false)
+			case ".irpc":
+				expanded, err = expand_irp(args, body, true)
+			}
+			if err != nil {
+				return nil, err
+			}
+			// body may contain its own repetition blocks
+			expanded, err = expand_repetitions(expanded)
+			if err != nil {
+				return nil, err
+			}
+			if len(out)+len(expanded) > maxExpandedLines {
+				return nil, fmt.Errorf("%s expands to more than %d lines", op_split[0], maxExpandedLines)
+			}
+			out = append(out, expanded...)
+			i = end
+		case ".endr":
+			return nil, fmt.Errorf(".endr without matching .rept/.irp/.irpc")
+		default:
+			out = append(out, line)
+		}
+	}
+	return out, nil
+}
+
+// returns index of the .endr closing the block opened at lines[start]
+func find_endr(lines []string, start int) (int, error) {
+	depth := 0
+	for i := start; i < len(lines); i++ {
+		switch strings.SplitN(lines[i], " ", 2)[0] {
+		case ".rept", ".irp", ".irpc":
+			depth++
+		case ".endr":
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("%q is missing its .endr", lines[start])
+}
+
+// .rept count
+func expand_rept(args string, body []string, equs map[string]int64) ([]string, error) {
+	cnt, err := strconv.ParseInt(args, 0, 64)
+	if err != nil {
+		val, ok := equs[args]
+		if !ok {
+			return nil, fmt.Errorf(".rept count %q is not a number", args)
+		}
+		cnt = val
+	}
+	if cnt < 0 {
+		return nil, fmt.Errorf(".rept count %d is negative", cnt)
+	}
+	if cnt > maxExpandedLines/int64(max(len(body), 1)) { //dividing so a huge count can't overflow
+		return nil, fmt.Errorf(".rept count %d expands to more than %d lines", cnt, maxExpandedLines)
+	}
+	out := make([]string, 0, int(cnt)*len(body))
+	for n := int64(0); n < cnt; n++ {
+		out = append(out, body...)
+	}
+	return out, nil
+}
+
+// .irp sym, a, b, c   substitutes \sym with each value in turn
+// .irpc sym, abc      substitutes \sym with each character in turn
+func expand_irp(args string, body []string, per_char bool) ([]string, error) {
+	fields := strings.FieldsFunc(args, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("repetition block is missing its symbol")
+	}
+	sym := fields[0]
+	var values []string
+	if per_char {
+		for _, f := range fields[1:] {
+			for _, c := range f {
+				values = append(values, string(c))
+			}
+		}
+	} else {
+		values = fields[1:]
+	}
+	if len(values) == 0 {
+		values = []string{""} // GNU expands the body once with an empty value
+	}
+
+	out := make([]string, 0, len(values)*len(body))
+	for _, val := range values {
+		for _, line := range body {
+			line = substitute_arg(line, sym, val)
+			line = strings.ReplaceAll(line, "\\()", "")
+			out = append(out, line)
+		}
+	}
+	return out, nil
+}
+
+// replaces \sym with val where sym is not just the start of a longer name (\reg under .irp r)
+func substitute_arg(line, sym, val string) string {
+	var sb strings.Builder
+	for {
+		idx := strings.Index(line, "\\"+sym)
+		if idx < 0 {
+			break
+		}
+		end := idx + 1 + len(sym)
+		if end < len(line) && is_ident_char(line[end]) {
+			sb.WriteString(line[:end])
+		} else {
+			sb.WriteString(line[:idx] + val)
+		}
+		line = line[end:]
+	}
+	return sb.String() + line
+}
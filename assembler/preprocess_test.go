@@ -0,0 +1,70 @@
+package assembler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRepetitions(t *testing.T) {
+	cases := []struct {
+		src  string
+		want []string
+	}{
+		{".rept 3\n.byte 1\n.endr", []string{".byte 1", ".byte 1", ".byte 1"}},
+		{".rept 0\n.byte 1\n.endr\n.byte 2", []string{".byte 2"}},
+		{".rept 0x2 # hex count\naddi a0, a0, 1\n.endr", []string{"addi a0, a0, 1", "addi a0, a0, 1"}},
+		{".equ N, 2\n.rept N\n.word 0\n.endr", []string{".equ N, 2", ".word 0", ".word 0"}},
+		{".irp r, a0, a1\naddi \\r, \\r, 1\n.endr", []string{"addi a0, a0, 1", "addi a1, a1, 1"}},
+		{".irp r a0 a1\nmv_\\r:\n.endr", []string{"mv_a0:", "mv_a1:"}},
+		{".irp r\n.byte 1\\r\n.endr", []string{".byte 1"}},
+		{".irpc c, 123\n.byte \\c\n.endr", []string{".byte 1", ".byte 2", ".byte 3"}},
+		{".irpc n, 01\nx\\n\\():\n.endr", []string{"x0:", "x1:"}},
+		{".rept 2\n.irp v, 1, 2\n.byte \\v\n.endr\n.endr", []string{".byte 1", ".byte 2", ".byte 1", ".byte 2"}},
+		{".irp n, 1, 2\n.rept \\n\n.byte \\n\n.endr\n.endr", []string{".byte 1", ".byte 2", ".byte 2"}},
+		{".irp r, a0\n.irp rx, a1\nmv \\r, \\rx\n.endr\n.endr", []string{"mv a0, a1"}},
+		{".irp r, a0\nmv \\reg, \\r\n.endr", []string{"mv \\reg, a0"}},
+	}
+	for _, c := range cases {
+		got, err := ParseSource(c.src)
+		if err != nil {
+			t.Errorf("%q: %v", c.src, err)
+			continue
+		}
+		if strings.Join(got, "\n") != strings.Join(c.want, "\n") {
+			t.Errorf("%q:\n got %q\nwant %q", c.src, got, c.want)
+		}
+	}
+}
+
+func TestRepetitionRejects(t *testing.T) {
+	for _, src := range []string{
+		".rept 3\n.byte 1",
+		".endr",
+		".rept 2\n.rept 2\n.endr",
+		".rept -1\n.endr",
+		".rept N\n.endr",
+		".rept 0x7fffffffffffffff\n.byte 1\n.endr",
+		".rept 0x4000000000000000\n.byte 1\n.byte 2\n.byte 3\n.byte 4\n.endr",
+		".rept 1024\n.rept 1024\n.rept 2\n.byte 1\n.endr\n.endr\n.endr",
+		".irp\n.endr",
+	} {
+		if _, err := ParseSource(src); err == nil {
+			t.Errorf("%q was accepted", src)
+		}
+	}
+}
+
+// the expanded lines are laid out like any others
+func TestRepetitionLayout(t *testing.T) {
+	lines, err := ParseSource(".rept 4\naddi a0, a0, 1\n.endr\nend:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin, err := Assemble(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if end, err := lookup_symbol("end", 0); len(bin) != 16 || err != nil || end != 16 {
+		t.Errorf("image %d bytes, end at %d (%v), want 16", len(bin), end, err)
+	}
+}
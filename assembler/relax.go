@@ -0,0 +1,348 @@
+package assembler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Relax is cleared by --no-relax. Since there is no separate linker the assembler does the linker's relaxations
+// itself while it lays the program out: call/tail start as a single jal (only growing to auipc+jalr when the
+// target is out of reach), la and lui+%lo sequences become one gp relative instruction when the target is within
+// 2 KiB of __global_pointer$. That only happens when the program defines __global_pointer$ itself, since its
+// startup code is what loads gp. Every layout recomputes .align padding, so alignment follows the shrunk code
+var Relax = true
+
+var optionRelax = true                //.option relax/norelax for the lines being laid out
+var optionStack = make([]bool, 0)     //.option push/pop
+var norelaxLines = make(map[int]bool) //lines assembled under .option norelax
+
+// how far each branch/jump has been grown (or each la shrunk), by line index. Lines that are not listed keep
+// their normal encoding
+var relaxLevels = make(map[int]int)
+var relaxPinned = make(map[int]bool)  //la lines that stopped fitting once shrunk, never shrunk again
+var gpRelaxed = make(map[string]bool) //x of %hi(x) whose lui was dropped, its %lo(x) users go off gp
+var gpPinned = make(map[string]bool)
+
+const (
+	relaxNone = iota
+	relaxFar  // branch: inverted branch over a jal. jal: auipc+jalr
+	relaxGP   // la: addi rd, gp, sym-gp
+)
+
+// .option relax|norelax|push|pop. pic/nopic/norvc change nothing for this assembler
+func set_option(op_split []string) error {
+	if len(op_split) != 2 {
+		return fmt.Errorf(".option takes one option")
+	}
+	switch strings.TrimSpace(op_split[1]) {
+	case "relax":
+		optionRelax = Relax //--no-relax wins
+	case "norelax":
+		optionRelax = false
+	case "push":
+		optionStack = append(optionStack, optionRelax)
+	case "pop":
+		if len(optionStack) == 0 {
+			return fmt.Errorf(".option pop without .option push")
+		}
+		optionRelax = optionStack[len(optionStack)-1]
+		optionStack = optionStack[:len(optionStack)-1]
+	case "pic", "nopic", "norvc":
+	case "rvc":
+		return fmt.Errorf("compressed instructions are not supported")
+	default:
+		return fmt.Errorf("unknown .option %s", op_split[1])
+	}
+	return nil
+}
+
+// call and tail are jal with ra / x0. When they are grown, call reuses ra and tail clobbers t1 like GNU as
+func expand_call(op_split []string) []string {
+	if len(op_split) != 2 {
+		return op_split
+	}
+	switch op_split[0] {
+	case "call":
+		return []string{"jal", "ra, " + op_split[1]}
+	case "tail":
+		return []string{"jal", "x0, " + op_split[1]}
+	}
+	return op_split
+}
+
+// bytes the instruction at curr_idx takes with its current relaxation
+func instr_size(curr_idx int, op_split []string) ilen {
+	switch {
+	case op_split[0] == "la" || op_split[0] == "lla":
+		if relaxLevels[curr_idx] == relaxGP {
+			return ILEN_BYTES
+		}
+		return 2 * ILEN_BYTES
+	case op_split[0] == "call" || op_split[0] == "tail":
+		if norelaxLines[curr_idx] {
+			relaxLevels[curr_idx] = relaxFar //only relaxation turns them into a single jal
+		}
+	}
+	if key, part := gp_sequence_part(strings.Join(op_split, " ")); part == "hi" && gpRelaxed[key] {
+		return 0
+	}
+	if relaxLevels[curr_idx] == relaxNone {
+		return ILEN_BYTES
+	}
+	return 2 * ILEN_BYTES
+}
+
+// checks every branch/jump to a label against the addresses of the last layout and grows the ones that cannot
+// reach. Returns whether anything grew, in which case the layout has to be redone
+func grow_far_branches(instructions []string) bool {
+	grew := false
+	for i, line := range instructions {
+		if relaxLevels[i] != relaxNone {
+			continue //already as long as it gets
+		}
+		op_split := expand_call(strings.SplitN(line, " ", 2))
+		itype, ok := InstrTable[op_split[0]]
+		if !ok || len(op_split) != 2 || (itype.fmt != B && itype.fmt != J) {
+			continue
+		}
+		operands := split_operands(op_split[1])
+		if len(operands) == 0 {
+			continue
+		}
+		target, r := operands[len(operands)-1], bRange
+		if itype.fmt == J {
+			r = jRange
+		}
+		val, is_addr, err := imm_operand(target, i)
+		if err != nil || !is_addr {
+			continue //plain offsets are never rewritten, errors are reported by the second pass
+		}
+		offset := val - int64(instr_addresses[i])
+		if offset < r.min || offset > r.max {
+			fmt.Printf("%q: target is %d bytes away, growing it\n", line, offset)
+			relaxLevels[i] = relaxFar
+			grew = true
+		}
+	}
+	return grew
+}
+
+// b<cond> rs1, rs2, far  ->  b<!cond> rs1, rs2, 8 ; jal x0, far
+func emit_far_branch(curr_idx int, bin_arr []byte, itype InstrDesc, rs1 uint8, rs2 uint8, target string) error {
+	addr := instr_addresses[curr_idx]
+	offset, err := pc_offset(target, curr_idx)
+	if err != nil {
+		return err
+	}
+	offset -= int64(ILEN_BYTES) //the jal is one instruction further on
+	if err := check_imm("far branch", offset, jRange); err != nil {
+		return err
+	}
+	//beq/bne, blt/bge and bltu/bgeu only differ in the lowest funct3 bit
+	skip := pack_b(itype.Opcode, itype.funct3^1, rs1, rs2, int64(2*ILEN_BYTES))
+	jump := pack_j(InstrTable["jal"].Opcode, 0, offset)
+	fmt.Printf("far B instr: %032b %032b\n", skip, jump)
+	populate_bin_instruction(skip, addr, bin_arr)
+	populate_bin_instruction(jump, addr+ILEN_BYTES, bin_arr)
+	return nil
+}
+
+// jal rd, far  ->  auipc tmp, %hi(far) ; jalr rd, %lo(far)(tmp). tmp is rd itself, or t1 when rd is x0
+func emit_far_jump(curr_idx int, bin_arr []byte, rd uint8, target string) error {
+	addr := instr_addresses[curr_idx]
+	offset, err := pc_offset(target, curr_idx)
+	if err != nil {
+		return err
+	}
+	tmp := rd
+	if tmp == 0 {
+		tmp = regMap["t1"]
+	}
+	hi, lo := split_hi_lo(offset)
+	auipc := pack_u(InstrTable["auipc"].Opcode, tmp, hi)
+	jalr := pack_i(InstrTable["jalr"].Opcode, InstrTable["jalr"].funct3, rd, tmp, lo)
+	fmt.Printf("far J instr: %032b %032b\n", auipc, jalr)
+	populate_bin_instruction(auipc, addr, bin_arr)
+	populate_bin_instruction(jalr, addr+ILEN_BYTES, bin_arr)
+	return nil
+}
+
+// address of __global_pointer$ if the program defines one (label, .equ or .set), never made up
+func global_pointer() (int64, bool) {
+	if len(instr_addresses) == 0 {
+		return 0, false
+	}
+	gp, err := lookup_symbol("__global_pointer$", 0)
+	return gp, err == nil
+}
+
+// x of a %hi(x) / %lo(x) operand that is nothing but that
+func reloc_operand(op string, part string) (string, bool) {
+	if !strings.HasPrefix(op, part+"(") || !strings.HasSuffix(op, ")") {
+		return "", false
+	}
+	inner := op[len(part)+1 : len(op)-1]
+	depth := 0
+	for i := 0; i < len(inner); i++ {
+		switch inner[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return "", false //%lo(a)+(b)
+			}
+		}
+	}
+	return strings.TrimSpace(inner), depth == 0
+}
+
+// offset part of an offset(reg) operand, or the operand itself
+func mem_offset(op string) string {
+	open := strings.LastIndex(op, "(")
+	if open < 0 || !strings.HasSuffix(op, ")") {
+		return op
+	}
+	if _, ok := regMap[strings.TrimSpace(op[open+1:len(op)-1])]; !ok {
+		return op
+	}
+	return strings.TrimSpace(op[:open])
+}
+
+// classifies a line that uses %hi/%lo: "hi" for lui rd, %hi(x) and "lo" for an I/S instruction whose immediate
+// is %lo(x). Anything else gives ""
+func gp_sequence_part(line string) (string, string) {
+	op_split := strings.SplitN(line, " ", 2)
+	itype, ok := InstrTable[op_split[0]]
+	if !ok || len(op_split) != 2 {
+		return "", ""
+	}
+	operands := split_operands(op_split[1])
+	if len(operands) == 0 {
+		return "", ""
+	}
+	last := operands[len(operands)-1]
+	switch {
+	case op_split[0] == "lui" && len(operands) == 2:
+		if key, ok := reloc_operand(last, "%hi"); ok {
+			return key, "hi"
+		}
+	case itype.fmt == I || itype.fmt == S:
+		if key, ok := reloc_operand(mem_offset(last), "%lo"); ok {
+			return key, "lo"
+		}
+	}
+	return "", ""
+}
+
+// immediate of a %lo(x) operand once its lui has been relaxed away: the offset of x from gp
+func gp_relative(op string, curr_idx int) (int64, bool) {
+	key, ok := reloc_operand(mem_offset(op), "%lo")
+	if !ok || !gpRelaxed[key] {
+		return 0, false
+	}
+	gp, ok := global_pointer()
+	target, err := eval_expr(key, curr_idx)
+	if !ok || err != nil {
+		return 0, false
+	}
+	return target - gp, true
+}
+
+// the other direction of grow_far_branches: la and lui+%lo sequences close enough to gp are shrunk, and
+// anything shrunk earlier that no longer reaches goes back to its long form for good. Returns whether anything
+// changed size
+func shrink_gp_sequences(instructions []string) bool {
+	gp, ok := global_pointer()
+	if !ok {
+		return false
+	}
+	in_reach := func(expr string, curr_idx int) bool {
+		target, err := eval_expr(expr, curr_idx)
+		return err == nil && target-gp >= iRange.min && target-gp <= iRange.max
+	}
+	changed := false
+
+	hi_lines := make(map[string][]int)
+	lo_users := make(map[string]int)
+	unsafe := false //a %hi/%lo used some other way might still need the lui
+	for i, line := range instructions {
+		op_split := strings.SplitN(line, " ", 2)
+		if (op_split[0] == "la" || op_split[0] == "lla") && len(op_split) == 2 {
+			operands := split_operands(op_split[1])
+			fits := len(operands) == 2 && operands[0] != "gp" && operands[0] != "x3" &&
+				!norelaxLines[i] && !relaxPinned[i] && in_reach(operands[1], i)
+			if relaxLevels[i] == relaxGP && !fits {
+				relaxLevels[i] = relaxNone
+				relaxPinned[i] = true
+				changed = true
+			} else if relaxLevels[i] == relaxNone && fits {
+				relaxLevels[i] = relaxGP
+				changed = true
+			}
+			continue
+		}
+		if !strings.Contains(line, "%hi(") && !strings.Contains(line, "%lo(") {
+			continue
+		}
+		key, part := gp_sequence_part(line)
+		switch part {
+		case "hi":
+			hi_lines[key] = append(hi_lines[key], i)
+		case "lo":
+			lo_users[key]++
+		default:
+			unsafe = true
+		}
+		if norelaxLines[i] {
+			gpPinned[key] = true
+		}
+	}
+	for key, lines := range hi_lines {
+		fits := !unsafe && !gpPinned[key] && lo_users[key] > 0 && in_reach(key, lines[0])
+		if gpRelaxed[key] && !fits {
+			gpRelaxed[key] = false
+			gpPinned[key] = true
+			changed = true
+		} else if !gpRelaxed[key] && fits {
+			gpRelaxed[key] = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+// la/lla rd, sym  ->  auipc rd, %pcrel_hi(sym) ; addi rd, rd, %pcrel_lo(sym), or addi rd, gp, sym-gp once relaxed
+func emit_address_load(curr_idx int, bin_arr []byte, op_split []string) error {
+	if len(op_split) != 2 {
+		return fmt.Errorf("%s takes rd, symbol", op_split[0])
+	}
+	operands := split_operands(op_split[1])
+	if err := operand_count(op_split[0], operands, 2); err != nil {
+		return err
+	}
+	rd, err := reg_operand(operands[0])
+	if err != nil {
+		return err
+	}
+	target, _, err := imm_operand(operands[1], curr_idx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op_split[0], err)
+	}
+	addr := instr_addresses[curr_idx]
+	addi := InstrTable["addi"]
+	if relaxLevels[curr_idx] == relaxGP {
+		gp, _ := global_pointer()
+		instruction := pack_i(addi.Opcode, addi.funct3, rd, regMap["gp"], target-gp)
+		fmt.Printf("gp %s instr: %032b\n", op_split[0], instruction)
+		populate_bin_instruction(instruction, addr, bin_arr)
+		return nil
+	}
+	hi, lo := split_hi_lo(target - int64(addr))
+	auipc := pack_u(InstrTable["auipc"].Opcode, rd, hi)
+	low := pack_i(addi.Opcode, addi.funct3, rd, rd, lo)
+	fmt.Printf("%s instr: %032b %032b\n", op_split[0], auipc, low)
+	populate_bin_instruction(auipc, addr, bin_arr)
+	populate_bin_instruction(low, addr+ILEN_BYTES, bin_arr)
+	return nil
+}
@@ -0,0 +1,128 @@
+package assembler
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// the instruction word at off in an image
+func word_at(bin []byte, off int64) uint32 {
+	return binary.LittleEndian.Uint32(bin[off:])
+}
+
+func TestFarBranches(t *testing.T) {
+	cases := []struct {
+		name  string
+		lines []string
+		want  map[int64]string // instructions by address
+	}{
+		{"near", []string{"beq a0, a1, f", ".space 4088", "f:", "addi zero, zero, 0"},
+			map[int64]string{0: "beq a0, a1, 4092"}},
+		{"forward", []string{"beq a0, a1, f", ".space 5000", "f:", "addi zero, zero, 0"},
+			map[int64]string{0: "bne a0, a1, 8", 4: "jal zero, 5004"}},
+		{"backward", []string{"f:", "addi zero, zero, 0", ".space 5000", "bltu a0, a1, f"},
+			map[int64]string{5004: "bgeu a0, a1, 8", 5008: "jal zero, -5008"}},
+		{"jal", []string{"jal ra, f", ".space 0x100000", "f:", "addi zero, zero, 0"},
+			map[int64]string{0: "auipc ra, 0x100", 4: "jalr ra, 8(ra)"}},
+		{"call", []string{"call f", ".space 0x100000", "f:", "addi zero, zero, 0"},
+			map[int64]string{0: "auipc ra, 0x100", 4: "jalr ra, 8(ra)"}},
+		{"tail", []string{"tail f", ".space 0x100000", "f:", "addi zero, zero, 0"},
+			map[int64]string{0: "auipc t1, 0x100", 4: "jalr zero, 8(t1)"}},
+		// the second branch growing pushes f out of the first one's reach, so the first grows on the next round
+		{"fixed point", []string{"beq a0, a1, f", ".space 4084", "beq a2, a3, g", "f:", "addi zero, zero, 0", ".space 8192", "g:"},
+			map[int64]string{0: "bne a0, a1, 8", 4: "jal zero, 4096", 4092: "bne a2, a3, 8", 4096: "jal zero, 8200", 4100: "addi zero, zero, 0"}},
+	}
+	for _, c := range cases {
+		bin := assemble_image(t, c.lines)
+		for off, want := range c.want {
+			if got, want_word := word_at(bin, off), assemble_word(t, want); got != want_word {
+				t.Errorf("%s: %08x at %d, want %08x (%s)", c.name, got, off, want_word, want)
+			}
+		}
+	}
+	// a plain offset is what the programmer asked for, so it is never rewritten
+	if _, err := Assemble([]string{"beq a0, a1, 8192"}); err == nil {
+		t.Error("out of range branch offset was accepted")
+	}
+}
+
+func TestRelax(t *testing.T) {
+	cases := []struct {
+		name    string
+		lines   []string
+		norelax bool // --no-relax
+		want    map[int64]string
+		f       int64 // address of f
+	}{
+		{"call", []string{"call f", "f:"}, false, map[int64]string{0: "jal ra, 4"}, 4},
+		{"tail", []string{"tail f", "f:"}, false, map[int64]string{0: "jal zero, 4"}, 4},
+		{"no-relax", []string{"call f", "f:"}, true, map[int64]string{0: "auipc ra, 0x0", 4: "jalr ra, 8(ra)"}, 8},
+		{"no-relax wins", []string{".option relax", "call f", "f:"}, true, map[int64]string{0: "auipc ra, 0x0"}, 8},
+		{"option", []string{".option norelax", "call f", ".option relax", "call f", "f:"}, false,
+			map[int64]string{0: "auipc ra, 0x0", 4: "jalr ra, 12(ra)", 8: "jal ra, 4"}, 12},
+		{"push/pop", []string{".option push", ".option norelax", "tail f", ".option pop", "tail f", "f:"}, false,
+			map[int64]string{0: "auipc t1, 0x0", 4: "jalr zero, 12(t1)", 8: "jal zero, 4"}, 12},
+		// .align is worked out again after the call shrinks
+		{"align", []string{"call f", "addi zero, zero, 1", ".balign 8", "f:"}, false, map[int64]string{4: "addi zero, zero, 1"}, 8},
+		{"align no-relax", []string{"call f", "addi zero, zero, 1", ".balign 8", "f:"}, true,
+			map[int64]string{8: "addi zero, zero, 1", 12: "addi zero, zero, 0"}, 16},
+		// gp relative only within 2 KiB of __global_pointer$, and never under norelax
+		{"gp", []string{"la a0, f", ".data", "__global_pointer$:", ".space 2047", "f:"}, false, map[int64]string{0: "addi a0, gp, 2047"}, 2051},
+		{"gp out of reach", []string{"la a0, f", ".data", "__global_pointer$:", ".space 2048", "f:"}, false, map[int64]string{0: "auipc a0, 0x1", 4: "addi a0, a0, -2040"}, 2056},
+		{"gp norelax", []string{".option norelax", "la a0, f", ".data", "__global_pointer$:", "f:"}, false, map[int64]string{0: "auipc a0, 0x0", 4: "addi a0, a0, 8"}, 8},
+		{"gp no-relax", []string{"la a0, f", ".data", "__global_pointer$:", "f:"}, true, map[int64]string{0: "auipc a0, 0x0"}, 8},
+	}
+	t.Cleanup(func() { Relax = true })
+	for _, c := range cases {
+		Relax = !c.norelax
+		bin := assemble_image(t, c.lines)
+		if f, err := lookup_symbol("f", 0); err != nil || f != c.f {
+			t.Errorf("%s: f at %d (%v), want %d", c.name, f, err, c.f)
+		}
+		Relax = true
+		for off, want := range c.want {
+			if got, want_word := word_at(bin, off), assemble_word(t, want); got != want_word {
+				t.Errorf("%s: %08x at %d, want %08x (%s)", c.name, got, off, want_word, want)
+			}
+		}
+	}
+}
+
+func TestOptionRejects(t *testing.T) {
+	for _, line := range []string{".option pop", ".option rvc", ".option", ".option foo", ".option relax, norelax"} {
+		if _, err := Assemble([]string{line}); err == nil {
+			t.Errorf("%q was accepted", line)
+		}
+	}
+}
+
+func TestGlobalPointer(t *testing.T) {
+	cases := []struct {
+		name  string
+		lines []string
+		word  uint32 // first instruction
+	}{
+		// no __global_pointer$, so nothing may assume gp holds one
+		{"undefined", []string{"la a0, buf", "addi zero, zero, 0", ".data", "buf: .word 0"}, 0x00000517},                           // auipc a0, 0
+		{"label", []string{"la a0, buf", ".data", "__global_pointer$:", "buf: .word 0"}, 0x00018513},                               // addi a0, gp, 0
+		{"equ", []string{".equ __global_pointer$, buf + 8", "la a0, buf", ".data", "buf: .word 0"}, 0xff818513},                    // addi a0, gp, -8
+		{"lui", []string{"lui a0, %hi(buf)", "lw a0, %lo(buf)(a0)", ".data", "buf: .word 0"}, 0x00000537},                          // lui a0, 0
+		{"lui gp", []string{"lui a0, %hi(buf)", "lw a0, %lo(buf)(a0)", ".data", "__global_pointer$:", "buf: .word 0"}, 0x0001a503}, // lw a0, 0(gp)
+	}
+	for _, c := range cases {
+		bin, err := Assemble(c.lines)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if word := word_at(bin, 0); word != c.word {
+			t.Errorf("%s: first word %08x, want %08x", c.name, word, c.word)
+		}
+	}
+	if _, err := Assemble([]string{"la a0, buf", ".data", "buf: .word 0"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := elf_symbols(t)["__global_pointer$"]; ok {
+		t.Error("__global_pointer$ made up for a program without one")
+	}
+}
@@ -6,21 +6,59 @@ type ilen uint32 // instruction length
 type reg uint64  // register size depending if it is rv64 or rv32
 
 const ILEN_BYTES ilen = 4
-const BYTE_SZ ilen = 8    //bits per byte
-const BASE_ADDR = 0x10000 //Base address where bin files are loaded to be executed
+const BYTE_SZ ilen = 8      //bits per byte
+const BASE_ADDR = 0x10000   //Base address where bin files are loaded to be executed
+const NOP ilen = 0x00000013 //addi x0, x0, 0
 
 type Section struct {
-	name string
-	addr ilen
-	sz   ilen //byte buffer size in BYTES. initialize this to 0.
+	name   string
+	addr   ilen
+	sz     ilen //byte buffer size in BYTES. initialize this to 0.
+	flags  SecFlags
+	nobits bool   //@nobits: occupies address space but no bytes in the file (.bss)
+	align  ilen   //largest alignment requested inside the section
+	data   []byte //contents of sections that are not part of the loaded image
 }
+
+// section attributes from the "awx" flag string of .section
+type SecFlags uint8
+
+const (
+	SecAlloc SecFlags = 1 << iota // a: occupies memory when loaded
+	SecWrite                      // w: writable
+	SecExec                       // x: executable
+)
+
 type Symbol struct {
-	section *Section
+	section *Section // nil until the symbol is defined
 	name    string
 	offset  ilen // offset to section base address
 	global  bool
+	weak    bool
+	typ     SymType
+	size    ilen // .size in bytes
+	vis     SymVis
 }
 
+// .type of a symbol
+type SymType uint8
+
+const (
+	SymNoType SymType = iota
+	SymFunc
+	SymObject
+)
+
+// symbol visibility from .hidden/.protected/.internal
+type SymVis uint8
+
+const (
+	VisDefault SymVis = iota
+	VisInternal
+	VisHidden
+	VisProtected
+)
+
 // register mapping
 var regMap = make(map[string]uint8, 64)
 
@@ -37,86 +75,99 @@ const (
 	B InstrFmt = 0b1100011 // branches
 	U InstrFmt = 0b0110111 // lui, auipc
 	J InstrFmt = 0b1101111 // jumps
-	C InstrFmt = 0b1110011 // system
+	C InstrFmt = 0b1110011 // system (CSR accesses, fences and hypervisor loads/stores; ecall/ebreak are I)
+	V InstrFmt = 0b1010111 // vector (OP-V, plus vector loads/stores on LOAD-FP/STORE-FP)
+	F InstrFmt = 0b1010011 // floating point (OP-FP, fused multiply-adds, FP loads/stores)
+	A InstrFmt = 0b0101111 // atomics (AMO)
 )
 
-type InstrExt uint8
+// extensions besides the base integer ISA, one bit each. An instruction several extensions provide (rol is
+// in Zbb and Zbkb) has all their bits set and is available with any of them
+type InstrExt uint64
 
 const (
-	ExtNone InstrExt = 0b0
+	ExtNone InstrExt = 0
+	ExtM    InstrExt = 1 << (iota - 1)
+	ExtA
+	ExtF
+	ExtD
+	ExtQ
+	ExtC
+	ExtV
+	ExtH
+	ExtZicsr
+	ExtZifencei
+	ExtB
+	ExtZba
+	ExtZbb
+	ExtZbc
+	ExtZbs
+	ExtZbkb
+	ExtZbkc
+	ExtZbkx
+	ExtZkn
+	ExtZknd
+	ExtZkne
+	ExtZknh
+	ExtZks
+	ExtZksed
+	ExtZksh
+	ExtZicond
+	ExtZfh
+	ExtZfhmin
+	ExtZfbfmin
 )
 
+// derived from the opcodes/ definition: match holds every fixed bit, mask says which bits those are
 type InstrDesc struct {
 	fmt    InstrFmt
 	Opcode uint8
 	funct3 uint8
 	funct7 uint8
 	ext    InstrExt
+	needs  InstrExt //extensions required on top of one from ext (fcvt.d.h needs D)
+	match  uint32
+	mask   uint32
+	args   []string //operand fields in riscv-opcodes naming
+	mem    bool     //I type written rd, imm(rs1) rather than rd, rs1, imm
+	xext   string   //custom extension from an --isa-ext file, "" for the built-in ones
+}
+
+// ABI name of each register, also used by the disassembler
+var abiNames = []string{
+	"zero", "ra", "sp", "gp", "tp",
+	"t0", "t1", "t2",
+	"s0", "s1",
+	"a0", "a1", "a2", "a3", "a4", "a5", "a6", "a7",
+	"s2", "s3", "s4", "s5", "s6", "s7", "s8", "s9", "s10", "s11",
+	"t3", "t4", "t5", "t6",
+}
+
+// floating point registers, kept apart from regMap so an integer operand never takes one
+var fregMap = make(map[string]uint8, 64)
+
+var fabiNames = []string{
+	"ft0", "ft1", "ft2", "ft3", "ft4", "ft5", "ft6", "ft7",
+	"fs0", "fs1",
+	"fa0", "fa1", "fa2", "fa3", "fa4", "fa5", "fa6", "fa7",
+	"fs2", "fs3", "fs4", "fs5", "fs6", "fs7", "fs8", "fs9", "fs10", "fs11",
+	"ft8", "ft9", "ft10", "ft11",
 }
 
 func populate_regMap() {
-	abiNames := []string{
-		"zero", "ra", "sp", "gp", "tp",
-		"t0", "t1", "t2",
-		"s0", "s1",
-		"a0", "a1", "a2", "a3", "a4", "a5", "a6", "a7",
-		"s2", "s3", "s4", "s5", "s6", "s7", "s8", "s9", "s10", "s11",
-		"t3", "t4", "t5", "t6",
-	}
 	for i, reg := range abiNames {
 		regMap[reg] = uint8(i)
 		regMap[fmt.Sprintf("x%d", i)] = uint8(i)
 	}
+	for i, reg := range fabiNames {
+		fregMap[reg] = uint8(i)
+		fregMap[fmt.Sprintf("f%d", i)] = uint8(i)
+	}
 }
 func populate_instrTable() {
-	//R Instructions
-	InstrTable["add"] = InstrDesc{fmt: R, Opcode: uint8(R), funct3: 0x0, funct7: 0x00, ext: ExtNone}
-	InstrTable["sub"] = InstrDesc{fmt: R, Opcode: uint8(R), funct3: 0x0, funct7: 0x20, ext: ExtNone}
-	InstrTable["xor"] = InstrDesc{fmt: R, Opcode: uint8(R), funct3: 0x4, funct7: 0x00, ext: ExtNone}
-	InstrTable["or"] = InstrDesc{fmt: R, Opcode: uint8(R), funct3: 0x6, funct7: 0x00, ext: ExtNone}
-	InstrTable["and"] = InstrDesc{fmt: R, Opcode: uint8(R), funct3: 0x7, funct7: 0x00, ext: ExtNone}
-	InstrTable["sll"] = InstrDesc{fmt: R, Opcode: uint8(R), funct3: 0x1, funct7: 0x00, ext: ExtNone}
-	InstrTable["srl"] = InstrDesc{fmt: R, Opcode: uint8(R), funct3: 0x5, funct7: 0x20, ext: ExtNone}
-	InstrTable["sra"] = InstrDesc{fmt: R, Opcode: uint8(R), funct3: 0x2, funct7: 0x00, ext: ExtNone}
-	InstrTable["slt"] = InstrDesc{fmt: R, Opcode: uint8(R), funct3: 0x3, funct7: 0x00, ext: ExtNone}
-	InstrTable["sltu"] = InstrDesc{fmt: R, Opcode: uint8(R), funct3: 0x7, funct7: 0x00, ext: ExtNone}
-	//I Instructions
-	InstrTable["addi"] = InstrDesc{fmt: I, Opcode: uint8(I), funct3: 0x0, funct7: 0, ext: ExtNone}
-	InstrTable["xori"] = InstrDesc{fmt: I, Opcode: uint8(I), funct3: 0x4, funct7: 0, ext: ExtNone}
-	InstrTable["ori"] = InstrDesc{fmt: I, Opcode: uint8(I), funct3: 0x6, funct7: 0, ext: ExtNone}
-	InstrTable["andi"] = InstrDesc{fmt: I, Opcode: uint8(I), funct3: 0x7, funct7: 0, ext: ExtNone}
-	InstrTable["slli"] = InstrDesc{fmt: I, Opcode: uint8(I), funct3: 0x1, funct7: 0, ext: ExtNone}
-	InstrTable["srli"] = InstrDesc{fmt: I, Opcode: uint8(I), funct3: 0x5, funct7: 0, ext: ExtNone}
-	InstrTable["srai"] = InstrDesc{fmt: I, Opcode: uint8(I), funct3: 0x5, funct7: 0, ext: ExtNone}
-	InstrTable["slti"] = InstrDesc{fmt: I, Opcode: uint8(I), funct3: 0x2, funct7: 0, ext: ExtNone}
-	InstrTable["sltiu"] = InstrDesc{fmt: I, Opcode: uint8(I), funct3: 0x3, funct7: 0, ext: ExtNone}
-
-	InstrTable["lb"] = InstrDesc{fmt: I, Opcode: 0b0000011, funct3: 0x0, funct7: 0, ext: ExtNone}
-	InstrTable["lh"] = InstrDesc{fmt: I, Opcode: 0b0000011, funct3: 0x1, funct7: 0, ext: ExtNone}
-	InstrTable["lw"] = InstrDesc{fmt: I, Opcode: 0b0000011, funct3: 0x2, funct7: 0, ext: ExtNone}
-	InstrTable["lbu"] = InstrDesc{fmt: I, Opcode: 0b0000011, funct3: 0x4, funct7: 0, ext: ExtNone}
-	InstrTable["lhu"] = InstrDesc{fmt: I, Opcode: 0b0000011, funct3: 0x5, funct7: 0, ext: ExtNone}
-	//S Instructions
-	InstrTable["sb"] = InstrDesc{fmt: S, Opcode: uint8(S), funct3: 0x0, funct7: 0, ext: ExtNone}
-	InstrTable["sh"] = InstrDesc{fmt: S, Opcode: uint8(S), funct3: 0x1, funct7: 0, ext: ExtNone}
-	InstrTable["sw"] = InstrDesc{fmt: S, Opcode: uint8(S), funct3: 0x2, funct7: 0, ext: ExtNone}
-	//B Instructions
-	InstrTable["beq"] = InstrDesc{fmt: B, Opcode: uint8(B), funct3: 0x0, funct7: 0, ext: ExtNone}
-	InstrTable["bne"] = InstrDesc{fmt: B, Opcode: uint8(B), funct3: 0x1, funct7: 0, ext: ExtNone}
-	InstrTable["blt"] = InstrDesc{fmt: B, Opcode: uint8(B), funct3: 0x4, funct7: 0, ext: ExtNone}
-	InstrTable["bge"] = InstrDesc{fmt: B, Opcode: uint8(B), funct3: 0x5, funct7: 0, ext: ExtNone}
-	InstrTable["bltu"] = InstrDesc{fmt: B, Opcode: uint8(B), funct3: 0x6, funct7: 0, ext: ExtNone}
-	InstrTable["bgeu"] = InstrDesc{fmt: B, Opcode: uint8(B), funct3: 0x7, funct7: 0, ext: ExtNone}
-	//J Instructions
-	InstrTable["jal"] = InstrDesc{fmt: J, Opcode: 0b1101111, funct3: 0, funct7: 0, ext: ExtNone}
-	InstrTable["jalr"] = InstrDesc{fmt: I, Opcode: 0b1100111, funct3: 0x0, funct7: 0, ext: ExtNone}
-	//U Instructions
-	InstrTable["lui"] = InstrDesc{fmt: U, Opcode: 0b0110111, funct3: 0, funct7: 0, ext: ExtNone}
-	InstrTable["auipc"] = InstrDesc{fmt: U, Opcode: 0b0010111, funct3: 0, funct7: 0, ext: ExtNone}
-	//Transfer Instructions
-	InstrTable["ecall"] = InstrDesc{fmt: I, Opcode: 0b1110011, funct3: 0x0, funct7: 0, ext: ExtNone}
-	InstrTable["ebreak"] = InstrDesc{fmt: I, Opcode: 0b1110011, funct3: 0x0, funct7: 0, ext: ExtNone}
-
+	if err := load_opcodes(); err != nil {
+		panic(err) //the opcode files are built in, so this is a bug in them
+	}
 }
 
 func init() {
@@ -0,0 +1,276 @@
+package assembler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// random operands per instruction: each case is the source line and the text the disassembler should print
+const roundTripRuns = 64
+
+type operand_gen struct {
+	rng *rand.Rand
+}
+
+// register as the assembler takes it (ABI or xN name) and as the disassembler prints it
+func (g *operand_gen) reg() (string, string) {
+	r := g.rng.IntN(32)
+	if g.rng.IntN(2) == 0 {
+		return fmt.Sprintf("x%d", r), abiNames[r]
+	}
+	return abiNames[r], abiNames[r]
+}
+
+// value in [min, max], with the ends and zero showing up often. even keeps offsets instruction aligned
+func (g *operand_gen) imm(r imm_range) int64 {
+	var val int64
+	switch g.rng.IntN(5) {
+	case 0:
+		val = r.min
+	case 1:
+		val = r.max
+	case 2:
+		val = 0
+	default:
+		val = r.min + g.rng.Int64N(r.max-r.min+1)
+	}
+	if r.even {
+		val &^= 1
+	}
+	return val
+}
+
+func (g *operand_gen) instruction(name string, desc InstrDesc) (string, string, error) {
+	rd, rd_abi := g.reg()
+	rs1, rs1_abi := g.reg()
+	rs2, rs2_abi := g.reg()
+	switch desc.fmt {
+	case R:
+		if desc.has_arg("bs") {
+			bs := g.imm(bsRange)
+			return fmt.Sprintf("%s %s, %s, %s, %d", name, rd, rs1, rs2, bs), fmt.Sprintf("%s %s, %s, %s, %d", name, rd_abi, rs1_abi, rs2_abi, bs), nil
+		}
+		return fmt.Sprintf("%s %s, %s, %s", name, rd, rs1, rs2), fmt.Sprintf("%s %s, %s, %s", name, rd_abi, rs1_abi, rs2_abi), nil
+	case I:
+		switch {
+		case len(desc.args) == 0:
+			return name, name, nil
+		case desc.has_arg("shamtw"):
+			shamt := g.imm(shamtRange)
+			return fmt.Sprintf("%s %s, %s, %d", name, rd, rs1, shamt), fmt.Sprintf("%s %s, %s, %d", name, rd_abi, rs1_abi, shamt), nil
+		case !desc.has_arg("imm12"):
+			return fmt.Sprintf("%s %s, %s", name, rd, rs1), fmt.Sprintf("%s %s, %s", name, rd_abi, rs1_abi), nil
+		case !desc.mem:
+			imm := g.imm(iRange)
+			return fmt.Sprintf("%s %s, %s, %d", name, rd, rs1, imm), fmt.Sprintf("%s %s, %s, %d", name, rd_abi, rs1_abi, imm), nil
+		}
+		imm := g.imm(iRange) //loads and jalr
+		return fmt.Sprintf("%s %s, %d(%s)", name, rd, imm, rs1), fmt.Sprintf("%s %s, %d(%s)", name, rd_abi, imm, rs1_abi), nil
+	case S:
+		imm := g.imm(sRange)
+		return fmt.Sprintf("%s %s, %d(%s)", name, rs2, imm, rs1), fmt.Sprintf("%s %s, %d(%s)", name, rs2_abi, imm, rs1_abi), nil
+	case B:
+		off := g.imm(bRange)
+		return fmt.Sprintf("%s %s, %s, %d", name, rs1, rs2, off), fmt.Sprintf("%s %s, %s, %d", name, rs1_abi, rs2_abi, off), nil
+	case U:
+		imm := g.imm(uRange)
+		return fmt.Sprintf("%s %s, 0x%x", name, rd, imm), fmt.Sprintf("%s %s, 0x%x", name, rd_abi, imm), nil
+	case J:
+		off := g.imm(jRange)
+		return fmt.Sprintf("%s %s, %d", name, rd, off), fmt.Sprintf("%s %s, %d", name, rd_abi, off), nil
+	case V:
+		return g.vector(name, desc)
+	case F:
+		return g.float(name, desc)
+	case C:
+		return g.system(name, desc)
+	case A:
+		return g.atomic(name, desc)
+	}
+	return "", "", fmt.Errorf("no operand generator for %s (format %#x), add one when adding its extension", name, desc.fmt)
+}
+
+// vector operands in the order vector_operands gives, masked half of the time
+func (g *operand_gen) vector(name string, desc InstrDesc) (string, string, error) {
+	var src, want []string
+	add := func(s, w string) {
+		src, want = append(src, s), append(want, w)
+	}
+	for _, field := range vector_operands(desc) {
+		switch field {
+		case "rd", "rs1", "rs2":
+			add(g.reg())
+		case "vd", "vs1", "vs2", "vs3":
+			v := fmt.Sprintf("v%d", g.rng.IntN(32))
+			add(v, v)
+		case "mem":
+			r, abi := g.reg()
+			add("("+r+")", "("+abi+")")
+		case "simm5", "zimm5":
+			r := vsRange
+			if field == "zimm5" {
+				r = vuRange
+			}
+			imm := fmt.Sprintf("%d", g.imm(r))
+			add(imm, imm)
+		case "zimm10", "zimm11":
+			lmul := []uint32{0, 1, 2, 3, 5, 6, 7}[g.rng.IntN(7)]
+			vtype := vtype_string(lmul | uint32(g.rng.IntN(4))<<3 | uint32(g.rng.IntN(4))<<6)
+			add(vtype, vtype)
+		default:
+			return "", "", fmt.Errorf("no operand generator for vector field %s", field)
+		}
+	}
+	if desc.has_arg("vm") && g.rng.IntN(2) == 0 {
+		add("v0.t", "v0.t")
+	}
+	return name + " " + strings.Join(src, ", "), name + " " + strings.Join(want, ", "), nil
+}
+
+// floating point operands in the order float_operands gives, with a rounding mode some of the time
+func (g *operand_gen) float(name string, desc InstrDesc) (string, string, error) {
+	var src, want []string
+	add := func(s, w string) {
+		src, want = append(src, s), append(want, w)
+	}
+	freg := func() (string, string) {
+		r := g.rng.IntN(32)
+		if g.rng.IntN(2) == 0 {
+			return fmt.Sprintf("f%d", r), fabiNames[r]
+		}
+		return fabiNames[r], fabiNames[r]
+	}
+	for _, field := range float_operands(desc) {
+		switch field {
+		case "rd", "rs1":
+			add(g.reg())
+		case "frd", "frs1", "frs2", "frs3":
+			add(freg())
+		case "mem":
+			r, abi := g.reg()
+			imm := g.imm(iRange)
+			add(fmt.Sprintf("%d(%s)", imm, r), fmt.Sprintf("%d(%s)", imm, abi))
+		default:
+			return "", "", fmt.Errorf("no operand generator for floating point field %s", field)
+		}
+	}
+	if desc.has_arg("rm") && g.rng.IntN(2) == 0 {
+		modes := []string{"rne", "rtz", "rdn", "rup", "rmm", "dyn"}
+		mode := modes[g.rng.IntN(len(modes))]
+		src = append(src, mode)
+		if roundingModes[mode] != default_rounding_mode(name) {
+			want = append(want, mode)
+		}
+	}
+	return name + " " + strings.Join(src, ", "), name + " " + strings.Join(want, ", "), nil
+}
+
+// SYSTEM operands in the order system_operands gives, CSRs by number or by the name it disassembles to
+func (g *operand_gen) system(name string, desc InstrDesc) (string, string, error) {
+	var src, want []string
+	add := func(s, w string) {
+		src, want = append(src, s), append(want, w)
+	}
+	for _, field := range system_operands(desc) {
+		switch field {
+		case "rd", "rs1", "rs2":
+			add(g.reg())
+		case "mem":
+			r, abi := g.reg()
+			add("("+r+")", "("+abi+")")
+		case "csr":
+			num := uint32(g.rng.IntN(0x1000))
+			if g.rng.IntN(2) == 0 {
+				add(csr_name(num), csr_name(num))
+			} else {
+				add(fmt.Sprintf("%#x", num), csr_name(num))
+			}
+		case "zimm":
+			imm := fmt.Sprintf("%d", g.imm(vuRange))
+			add(imm, imm)
+		default:
+			return "", "", fmt.Errorf("no operand generator for system field %s", field)
+		}
+	}
+	return name + " " + strings.Join(src, ", "), name + " " + strings.Join(want, ", "), nil
+}
+
+// atomic operands in the order atomic_operands gives, with a random ordering suffix
+func (g *operand_gen) atomic(name string, desc InstrDesc) (string, string, error) {
+	name += []string{"", ".aq", ".rl", ".aqrl"}[g.rng.IntN(4)]
+	rd, rd_abi := g.reg()
+	rs1, rs1_abi := g.reg()
+	if !desc.has_arg("rs2") {
+		return fmt.Sprintf("%s %s, (%s)", name, rd, rs1), fmt.Sprintf("%s %s, (%s)", name, rd_abi, rs1_abi), nil
+	}
+	rs2, rs2_abi := g.reg()
+	return fmt.Sprintf("%s %s, %s, (%s)", name, rd, rs2, rs1), fmt.Sprintf("%s %s, %s, (%s)", name, rd_abi, rs2_abi, rs1_abi), nil
+}
+
+// the one word a single instruction assembles to
+func assemble_word(t *testing.T, line string) uint32 {
+	t.Helper()
+	image, err := Assemble([]string{line})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(image) != int(ILEN_BYTES) {
+		t.Fatalf("%q assembled to %d bytes", line, len(image))
+	}
+	return binary.LittleEndian.Uint32(image)
+}
+
+// instruction column of the listing, without the target comment
+func disassemble_word(t *testing.T, word uint32) string {
+	t.Helper()
+	code := binary.LittleEndian.AppendUint32(nil, word)
+	listing := strings.TrimSpace(Disassemble(code, 0))
+	fields := strings.Split(listing, "\t")
+	if len(fields) != 3 {
+		t.Fatalf("unexpected listing %q", listing)
+	}
+	text, _, _ := strings.Cut(fields[2], " #")
+	return text
+}
+
+// got is another instruction that fixes some of name's operand bits (zext.h is pack rd, rs1, zero), which the
+// decoder is right to prefer
+func is_specialization(name, got string) bool {
+	other, ok := InstrTable[strings.SplitN(got, " ", 2)[0]]
+	desc := InstrTable[name]
+	return ok && other.mask&desc.mask == desc.mask && other.mask != desc.mask && other.match&desc.mask == desc.match
+}
+
+// every InstrTable entry, assembled with random operands, has to disassemble to itself and reassemble to the
+// same word. Two entries sharing an encoding show up as the wrong mnemonic coming back
+func TestRoundTrip(t *testing.T) {
+	enable_all_exts(t)
+	names := make([]string, 0, len(InstrTable))
+	for name := range InstrTable {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			g := &operand_gen{rng: rand.New(rand.NewPCG(1, uint64(len(name))))}
+			for run := 0; run < roundTripRuns; run++ {
+				src, want, err := g.instruction(name, InstrTable[name])
+				if err != nil {
+					t.Fatal(err)
+				}
+				word := assemble_word(t, src)
+				got := disassemble_word(t, word)
+				if got != want && !is_specialization(name, got) {
+					t.Fatalf("%q -> %08x -> %q, want %q", src, word, got, want)
+				}
+				if again := assemble_word(t, got); again != word {
+					t.Fatalf("%q -> %08x -> %q -> %08x", src, word, got, again)
+				}
+			}
+		})
+	}
+}
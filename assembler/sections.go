@@ -0,0 +1,434 @@
+package assembler
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// .comm/.lcomm symbol waiting for space at the end of .bss
+type common struct {
+	sym   *Symbol
+	size  ilen
+	align ilen
+}
+
+// largest a section may grow, which keeps the image (and every address) well inside 32 bits
+const maxSectionSize ilen = 1 << 26
+
+var commons = make([]common, 0)
+var sectionStack = make([]string, 0) //.pushsection/.popsection
+var previousSection = ""             //.previous
+
+// clears everything FirstPass builds so it can be run again
+func reset_tables() {
+	symbolTable = make(map[string]*Symbol)
+	sectionTable = make(map[string]*Section)
+	sectionOrder = sectionOrder[:0]
+	localLabels = make(map[string][]int)
+	instr_addresses = instr_addresses[:0]
+	instr_sections = instr_sections[:0]
+	instr_sizes = instr_sizes[:0]
+	commons = commons[:0]
+	sectionStack = sectionStack[:0]
+	previousSection = ""
+	optionRelax = Relax
+	optionStack = optionStack[:0]
+	norelaxLines = make(map[int]bool)
+	activeISA = targetISA
+	attributes = make(map[uint64]attribute)
+}
+
+// switches to a section, creating it the first time it is seen. Re-entering a section continues at its end
+func enter_section(name string) *Section {
+	sec, ok := sectionTable[name]
+	if !ok {
+		flags, nobits := default_section_flags(name)
+		sec = &Section{name: name, addr: 0, sz: 0, flags: flags, nobits: nobits}
+		sectionTable[name] = sec
+		sectionOrder = append(sectionOrder, name)
+	}
+	return sec
+}
+
+// makes name the current section and remembers the old one for .previous
+func switch_section(section *string, name string) *Section {
+	previousSection = *section
+	*section = name
+	return enter_section(name)
+}
+
+// flags and type GNU gives well known section names. Anything else is not allocated unless flags are given
+func default_section_flags(name string) (SecFlags, bool) {
+	has_prefix := func(prefixes ...string) bool {
+		for _, prefix := range prefixes {
+			if name == prefix || strings.HasPrefix(name, prefix+".") {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case has_prefix(".text", ".init", ".fini"):
+		return SecAlloc | SecExec, false
+	case has_prefix(".bss", ".sbss", ".tbss"):
+		return SecAlloc | SecWrite, true
+	case has_prefix(".data", ".sdata", ".tdata", ".init_array", ".fini_array", ".preinit_array"):
+		return SecAlloc | SecWrite, false
+	case has_prefix(".rodata", ".srodata"):
+		return SecAlloc, false
+	}
+	return 0, false
+}
+
+// .section name[, "flags"[, @type]]. explicit is false when only the name was given
+func section_operands(op_split []string) (name string, flags SecFlags, nobits bool, explicit bool, err error) {
+	if len(op_split) < 2 || strings.TrimSpace(op_split[1]) == "" {
+		return "", 0, false, false, fmt.Errorf("%s needs a section name", op_split[0])
+	}
+	args := split_operands(op_split[1])
+	name = args[0]
+	if unquoted, err := strconv.Unquote(name); err == nil {
+		name = unquoted
+	}
+	if name == "" {
+		return "", 0, false, false, fmt.Errorf("%s needs a section name", op_split[0])
+	}
+	flags, nobits = default_section_flags(name)
+	if len(args) == 1 {
+		return name, flags, nobits, false, nil
+	}
+	if len(args) > 3 {
+		return "", 0, false, false, fmt.Errorf("%s takes name[, \"flags\"[, @type]]", op_split[0])
+	}
+
+	flag_str, err := strconv.Unquote(args[1])
+	if err != nil {
+		return "", 0, false, false, fmt.Errorf("%s flags %s must be quoted", op_split[0], args[1])
+	}
+	flags = 0
+	for _, c := range flag_str {
+		switch c {
+		case 'a':
+			flags |= SecAlloc
+		case 'w':
+			flags |= SecWrite
+		case 'x':
+			flags |= SecExec
+		case 'M', 'S': //mergeable/strings only matter to a linker that merges constants
+		default:
+			return "", 0, false, false, fmt.Errorf("unknown section flag %q in %s", c, args[1])
+		}
+	}
+	if len(args) == 3 {
+		switch strings.TrimLeft(args[2], "@%") {
+		case "progbits":
+			nobits = false
+		case "nobits":
+			nobits = true
+		default:
+			return "", 0, false, false, fmt.Errorf("unsupported section type %s", args[2])
+		}
+	}
+	return name, flags, nobits, true, nil
+}
+
+// .section/.pushsection with optional flags. Giving different flags for an existing section keeps the old ones
+func set_section(section *string, op_split []string) error {
+	name, flags, nobits, explicit, err := section_operands(op_split)
+	if err != nil {
+		return err
+	}
+	_, existed := sectionTable[name]
+	if op_split[0] == ".pushsection" {
+		sectionStack = append(sectionStack, *section)
+	}
+	sec := switch_section(section, name)
+	if !explicit {
+		return nil
+	}
+	if !existed {
+		sec.flags = flags
+		sec.nobits = nobits
+	} else if sec.flags != flags || sec.nobits != nobits {
+		fmt.Fprintf(os.Stderr, "warning: ignoring changed section attributes for %s\n", name) //not lost in the trace
+	}
+	return nil
+}
+
+// .popsection returns to the section before the matching .pushsection
+func pop_section(section *string) error {
+	if len(sectionStack) == 0 {
+		return fmt.Errorf(".popsection without .pushsection")
+	}
+	name := sectionStack[len(sectionStack)-1]
+	sectionStack = sectionStack[:len(sectionStack)-1]
+	switch_section(section, name)
+	return nil
+}
+
+// .previous swaps back to the section that was current before the last switch
+func previous_section(section *string) error {
+	if previousSection == "" {
+		return fmt.Errorf(".previous without an earlier section")
+	}
+	switch_section(section, previousSection)
+	return nil
+}
+
+// text for Print_Info, e.g. "ax progbits" or "aw nobits"
+func (sec *Section) flag_string() string {
+	out := ""
+	if sec.flags&SecAlloc != 0 {
+		out += "a"
+	}
+	if sec.flags&SecWrite != 0 {
+		out += "w"
+	}
+	if sec.flags&SecExec != 0 {
+		out += "x"
+	}
+	if sec.nobits {
+		return out + " nobits"
+	}
+	return out + " progbits"
+}
+
+// places allocated sections by permission like a linker script would: code, read-only data, writable data,
+// then NOBITS sections so they take address space after the image but no bytes in it. Sections keep the
+// order they first appear within each group. Non-allocated sections stay at address 0 and keep their
+// bytes to themselves. Converts line offsets into absolute addresses and returns the size of the file-backed image
+func layout_sections() ilen {
+	if len(commons) > 0 {
+		bss := enter_section(".bss")
+		for _, c := range commons {
+			bss.sz = ilen(align_size(reg(bss.sz), reg(c.align)))
+			c.sym.section = bss
+			c.sym.offset = bss.sz
+			bss.sz += c.size
+		}
+	}
+
+	addr := ilen(0)
+	var last *Section
+	for _, group := range []func(*Section) bool{
+		func(sec *Section) bool { return sec.flags&SecExec != 0 },
+		func(sec *Section) bool { return sec.flags&(SecExec|SecWrite) == 0 },
+		func(sec *Section) bool { return sec.flags&SecExec == 0 && sec.flags&SecWrite != 0 },
+	} {
+		for _, name := range sectionOrder {
+			sec := sectionTable[name]
+			if sec.flags&SecAlloc != 0 && !sec.nobits && group(sec) {
+				addr = align_section(addr, sec)
+				sec.addr = addr
+				addr += sec.sz
+				last = sec
+			}
+		}
+	}
+	bin_sz := addr
+
+	var first_bss, last_bss *Section
+	for _, name := range sectionOrder {
+		sec := sectionTable[name]
+		if sec.flags&SecAlloc == 0 {
+			sec.addr = 0
+			if !sec.nobits {
+				sec.data = make([]byte, sec.sz)
+			}
+		} else if sec.nobits {
+			addr = align_section(addr, sec)
+			sec.addr = addr
+			addr += sec.sz
+			if first_bss == nil {
+				first_bss = sec
+			}
+			last_bss = sec
+		}
+	}
+	// startup code clears [__bss_start, __bss_end)
+	if first_bss != nil {
+		define_layout_symbol("__bss_start", first_bss, 0)
+		define_layout_symbol("__bss_end", last_bss, last_bss.sz)
+	} else if last != nil {
+		define_layout_symbol("__bss_start", last, last.sz)
+		define_layout_symbol("__bss_end", last, last.sz)
+	}
+
+	for i := range instr_addresses {
+		instr_addresses[i] += instr_sections[i].addr
+	}
+	return bin_sz
+}
+
+// sections start on an instruction boundary or their own largest .align, whichever is bigger
+func align_section(addr ilen, sec *Section) ilen {
+	if sec.align > ILEN_BYTES {
+		return ilen(align_size(reg(addr), reg(sec.align)))
+	}
+	return align_addr(addr)
+}
+
+// code sections are padded with NOPs instead of zeros
+func is_code_section(name string) bool {
+	return sectionTable[name].flags&SecExec != 0
+}
+
+// .align and .p2align take a power of two (GNU RISC-V semantics), .balign a byte count. All three accept
+// an optional fill byte and a max skip: alignment is dropped when it would need more padding than that.
+// fill is -1 when not given so code sections can use NOPs
+func align_operands(op_split []string, curr_idx int) (ilen, int64, ilen, error) {
+	if len(op_split) < 2 || strings.TrimSpace(op_split[1]) == "" {
+		return 0, 0, 0, fmt.Errorf("%s needs an alignment", op_split[0])
+	}
+	args := split_operands(op_split[1])
+	if len(args) > 3 {
+		return 0, 0, 0, fmt.Errorf("%s takes align[, fill[, max]]", op_split[0])
+	}
+	vals := []int64{0, -1, 0}
+	var err error
+	for i, arg := range args {
+		if arg == "" && i > 0 { //.p2align 4,,8 leaves the fill out
+			continue
+		}
+		vals[i], err = eval_expr(arg, curr_idx)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	byte_align := vals[0]
+	if op_split[0] == ".balign" {
+		if byte_align <= 0 || byte_align&(byte_align-1) != 0 {
+			return 0, 0, 0, fmt.Errorf(".balign %d is not a power of two", byte_align)
+		}
+	} else {
+		if byte_align < 0 || byte_align > 16 {
+			return 0, 0, 0, fmt.Errorf("%s %d is outside 0..16 (2^n bytes)", op_split[0], byte_align)
+		}
+		byte_align = 1 << byte_align
+	}
+	if vals[1] < -1 || vals[1] > 0xFF {
+		return 0, 0, 0, fmt.Errorf("%s fill 0x%X does not fit in a byte", op_split[0], vals[1])
+	}
+	if vals[2] < 0 {
+		return 0, 0, 0, fmt.Errorf("%s max skip %d is negative", op_split[0], vals[2])
+	}
+	return ilen(byte_align), vals[1], ilen(vals[2]), nil
+}
+
+// bytes needed to bring addr up to byte_align. No padding when already aligned or when it exceeds a non-zero max_skip
+func align_padding(addr ilen, byte_align ilen, max_skip ilen) ilen {
+	pad := ilen(align_size(reg(addr), reg(byte_align))) - addr
+	if max_skip != 0 && pad > max_skip {
+		return 0
+	}
+	return pad
+}
+
+// pads size bytes at addr with fill, or with NOPs in code when no fill was given. Bytes before the first
+// instruction boundary are zero
+func fill_padding(bin_arr []byte, addr ilen, size ilen, fill int64, code bool) {
+	end := addr + size
+	if fill >= 0 || !code {
+		for i := addr; i < end; i++ {
+			bin_arr[i] = byte(max(fill, 0))
+		}
+		return
+	}
+	for ; addr < end && addr%ILEN_BYTES != 0; addr++ {
+		bin_arr[addr] = 0
+	}
+	for ; addr+ILEN_BYTES <= end; addr += ILEN_BYTES {
+		populate_bin_instruction(NOP, addr, bin_arr)
+	}
+	for ; addr < end; addr++ {
+		bin_arr[addr] = 0
+	}
+}
+
+// defines name unless the program does itself, like PROVIDE in a linker script
+func define_layout_symbol(name string, sec *Section, offset ilen) {
+	if _, ok := valueTable[name]; ok {
+		return
+	}
+	symbol, ok := symbolTable[name]
+	if !ok {
+		symbol = &Symbol{name: name, global: true}
+		symbolTable[name] = symbol
+	}
+	if symbol.section != nil {
+		return
+	}
+	symbol.section = sec
+	symbol.offset = offset
+}
+
+// .space/.skip size[, fill]
+func space_operands(op_split []string, curr_idx int) (ilen, byte, error) {
+	args, err := data_operands(op_split)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(args) > 2 {
+		return 0, 0, fmt.Errorf("%s takes size[, fill]", op_split[0])
+	}
+	size, err := eval_expr(args[0], curr_idx)
+	if err != nil {
+		return 0, 0, err
+	}
+	if size < 0 || size > int64(maxSectionSize) { //checked before ilen() can wrap it
+		return 0, 0, fmt.Errorf("%s size %d is out of range (0..%d)", op_split[0], size, maxSectionSize)
+	}
+	fill := int64(0)
+	if len(args) == 2 {
+		fill, err = eval_expr(args[1], curr_idx)
+		if err != nil {
+			return 0, 0, err
+		}
+		if fill < -128 || fill > 255 {
+			return 0, 0, fmt.Errorf("%s fill %d is out of range (-128..255)", op_split[0], fill)
+		}
+	}
+	return ilen(size), byte(fill), nil
+}
+
+// .comm sym, size[, align] declares a global common symbol, .lcomm a local one. Both are given space in .bss
+func declare_common(op_split []string, curr_idx int) error {
+	args, err := data_operands(op_split)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 || len(args) > 3 {
+		return fmt.Errorf("%s takes sym, size[, align]", op_split[0])
+	}
+	size, err := eval_expr(args[1], curr_idx)
+	if err != nil {
+		return err
+	}
+	if size < 0 || size > int64(maxSectionSize) {
+		return fmt.Errorf("%s %s size %d is out of range (0..%d)", op_split[0], args[0], size, maxSectionSize)
+	}
+	align := int64(1)
+	for align < size && align < 16 { //natural alignment, like GNU
+		align <<= 1
+	}
+	if len(args) == 3 {
+		align, err = eval_expr(args[2], curr_idx)
+		if err != nil {
+			return err
+		}
+		if align <= 0 || align&(align-1) != 0 {
+			return fmt.Errorf("%s %s alignment %d is not a power of two", op_split[0], args[0], align)
+		}
+	}
+
+	symbol, ok := symbolTable[args[0]]
+	if !ok {
+		symbol = &Symbol{name: args[0]}
+		symbolTable[args[0]] = symbol
+	}
+	symbol.global = symbol.global || op_split[0] == ".comm"
+	commons = append(commons, common{sym: symbol, size: ilen(size), align: ilen(align)})
+	return nil
+}
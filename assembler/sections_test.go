@@ -0,0 +1,187 @@
+package assembler
+
+import (
+	"debug/elf"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// the ELF WriteELF makes from the last assembly, closed when the test ends
+func write_elf(t *testing.T) *elf.File {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "out.elf")
+	WriteELF(filename)
+	f, err := elf.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestBSSLayout(t *testing.T) {
+	bin := assemble_image(t, []string{"addi zero, zero, 0", ".data", ".word 1", ".bss", "buf:", ".space 64", ".zero 8", ".skip 4"})
+	if len(bin) != 8 {
+		t.Errorf("image is %d bytes, want 8 (.bss takes no file bytes)", len(bin))
+	}
+	if got := fmt.Sprintf("%x", bin); got != "1300000001000000" {
+		t.Errorf("image %s, want the nop and the .word little endian", got)
+	}
+	bss := sectionTable[".bss"]
+	if bss.addr != 8 || bss.sz != 76 {
+		t.Errorf(".bss at %d size %d, want 8 and 76", bss.addr, bss.sz)
+	}
+	for name, want := range map[string]int64{"buf": 8, "__bss_start": 8, "__bss_end": 84} {
+		if got, err := lookup_symbol(name, 0); err != nil || got != want {
+			t.Errorf("%s = %d (%v), want %d", name, got, err, want)
+		}
+	}
+	sec := write_elf(t).Section(".bss")
+	if sec == nil || sec.Type != elf.SHT_NOBITS || sec.Size != 76 || sec.Flags != elf.SHF_ALLOC|elf.SHF_WRITE {
+		t.Errorf("ELF .bss: %+v", sec)
+	}
+
+	// without a .bss both symbols mark the end of the last section
+	FirstPass([]string{"addi zero, zero, 0", ".data", ".half 1"})
+	for _, name := range []string{"__bss_start", "__bss_end"} {
+		if got, err := lookup_symbol(name, 0); err != nil || got != 6 {
+			t.Errorf("%s = %d (%v), want 6", name, got, err)
+		}
+	}
+	// a program's own definitions win
+	FirstPass([]string{"__bss_start:", "addi zero, zero, 0", ".equ __bss_end, 0x100", ".bss", ".space 4"})
+	for name, want := range map[string]int64{"__bss_start": 0, "__bss_end": 0x100} {
+		if got, err := lookup_symbol(name, 0); err != nil || got != want {
+			t.Errorf("user defined %s = %d (%v), want %d", name, got, err, want)
+		}
+	}
+	// a section of type @nobits is laid out like .bss
+	FirstPass([]string{`.section .noinit, "aw", @nobits`, ".space 16", ".data", ".word 1"})
+	if noinit := sectionTable[".noinit"]; !noinit.nobits || noinit.addr != 4 {
+		t.Errorf(".noinit nobits %v at %d, want after .data", noinit.nobits, noinit.addr)
+	}
+}
+
+func TestCommon(t *testing.T) {
+	cases := []struct {
+		lines  []string
+		name   string
+		offset ilen // from the start of .bss
+		global bool
+	}{
+		{[]string{".comm buf, 16"}, "buf", 0, true},
+		{[]string{".lcomm buf, 16"}, "buf", 0, false},
+		{[]string{".bss", ".space 1", ".comm buf, 8"}, "buf", 8, true},         //natural alignment
+		{[]string{".bss", ".space 1", ".comm buf, 64"}, "buf", 16, true},       //which stops at 16
+		{[]string{".bss", ".space 1", ".lcomm buf, 64, 64"}, "buf", 64, false}, //unless given
+		{[]string{".comm a, 3", ".comm buf, 2"}, "buf", 4, true},               //in the order declared
+		{[]string{".bss", ".space 2", ".lcomm buf, 1, 1"}, "buf", 2, false},
+	}
+	for _, c := range cases {
+		FirstPass(c.lines)
+		sym := symbolTable[c.name]
+		if sym.section != sectionTable[".bss"] || sym.offset != c.offset || sym.global != c.global {
+			t.Errorf("%q: %s in %v at %d global %v, want .bss at %d global %v", c.lines, c.name, sym.section, sym.offset, sym.global, c.offset, c.global)
+		}
+	}
+}
+
+func TestNoBitsRejects(t *testing.T) {
+	for _, line := range []string{
+		".word 1",
+		".asciz \"x\"",
+		".space 4, 1",
+		".uleb128 1",
+		"addi zero, zero, 0",
+		".comm buf, -1",
+		".comm buf, 4, 3",
+		".comm buf",
+	} {
+		if _, err := Assemble([]string{".bss", line}); err == nil {
+			t.Errorf("%q in .bss was accepted", line)
+		}
+	}
+}
+
+func TestSectionFlags(t *testing.T) {
+	cases := []struct {
+		lines []string
+		name  string
+		flags elf.SectionFlag
+		typ   elf.SectionType
+	}{
+		{[]string{`.section .foo, "ax", @progbits`, "addi zero, zero, 0"}, ".foo", elf.SHF_ALLOC | elf.SHF_EXECINSTR, elf.SHT_PROGBITS},
+		{[]string{`.section .rodata.str, "aMS"`, ".byte 1"}, ".rodata.str", elf.SHF_ALLOC, elf.SHT_PROGBITS},
+		{[]string{`.section ".quoted", "aw"`, ".byte 1"}, ".quoted", elf.SHF_ALLOC | elf.SHF_WRITE, elf.SHT_PROGBITS},
+		{[]string{`.section .noinit, "aw", %nobits`, ".space 4"}, ".noinit", elf.SHF_ALLOC | elf.SHF_WRITE, elf.SHT_NOBITS},
+		{[]string{".section .sbss", ".space 4"}, ".sbss", elf.SHF_ALLOC | elf.SHF_WRITE, elf.SHT_NOBITS},
+		{[]string{".section .srodata", ".word 1"}, ".srodata", elf.SHF_ALLOC, elf.SHT_PROGBITS},
+		{[]string{".section .text.startup", "addi zero, zero, 0"}, ".text.startup", elf.SHF_ALLOC | elf.SHF_EXECINSTR, elf.SHT_PROGBITS},
+		{[]string{".section .comment", ".asciz \"v1\""}, ".comment", 0, elf.SHT_PROGBITS},
+		// the flags a section was created with stay
+		{[]string{`.section .foo, "a"`, ".byte 1", `.section .foo, "aw"`, ".byte 2"}, ".foo", elf.SHF_ALLOC, elf.SHT_PROGBITS},
+	}
+	for _, c := range cases {
+		assemble_image(t, c.lines)
+		sec := write_elf(t).Section(c.name)
+		if sec == nil || sec.Flags != c.flags || sec.Type != c.typ {
+			t.Errorf("%q: ELF section %+v, want flags %v type %v", c.lines, sec, c.flags, c.typ)
+		}
+	}
+}
+
+// code first, then read-only data, then writable data, whatever order the source uses
+func TestSectionPlacement(t *testing.T) {
+	FirstPass([]string{".data", ".word 1", ".rodata", ".word 2", `.section .other, "aw"`, ".word 3", ".text", "addi zero, zero, 0"})
+	for name, addr := range map[string]ilen{".text": 0, ".rodata": 4, ".data": 8, ".other": 12} {
+		if got := sectionTable[name].addr; got != addr {
+			t.Errorf("%s at %d, want %d", name, got, addr)
+		}
+	}
+}
+
+func TestSectionStack(t *testing.T) {
+	FirstPass([]string{
+		".data", ".word 1",
+		".pushsection .rodata", "r:", ".word 2",
+		".pushsection .text", "f:", "addi zero, zero, 0",
+		".popsection", "r2:",
+		".popsection", "d:", ".word 3",
+		".text", ".previous", "p:",
+		".previous", "f2:",
+	})
+	for name, sec := range map[string]string{"r": ".rodata", "f": ".text", "r2": ".rodata", "d": ".data", "p": ".data", "f2": ".text"} {
+		if got := symbolTable[name].section.name; got != sec {
+			t.Errorf("%s in %s, want %s", name, got, sec)
+		}
+	}
+	if off := symbolTable["d"].offset; off != 4 {
+		t.Errorf("d at offset %d of .data, want 4 (after the first .word)", off)
+	}
+}
+
+func TestSectionRejects(t *testing.T) {
+	for _, lines := range [][]string{
+		{".section"},
+		{`.section .x, "q"`},
+		{".section .x, aw"},
+		{`.section .x, "a", @note`},
+		{`.section .x, "a", @progbits, 1`},
+		{".popsection"},
+		{".previous"},
+		{".pushsection .rodata", ".popsection", ".popsection"},
+		{".data", "addi zero, zero, 0"},
+		{".data", ".space 4, 256"},
+		{".data", ".space 4, -129"},
+		{".data", ".space 0x100000000"},
+		{".bss", ".skip 0x100000000"},
+	} {
+		if _, err := Assemble(lines); err == nil {
+			t.Errorf("%q was accepted", lines)
+		}
+	}
+	if got := fmt.Sprintf("%x", assemble_image(t, []string{".space 2, -128", ".space 2, 255"})); got != "8080ffff" {
+		t.Errorf(".space fills %s, want 8080ffff", got)
+	}
+}
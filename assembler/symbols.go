@@ -0,0 +1,198 @@
+package assembler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// returns the symbol called name, creating an undefined one if it has not been seen yet
+func symbol_attrs(name string) *Symbol {
+	symbol, ok := symbolTable[name]
+	if !ok {
+		symbol = &Symbol{name: name}
+		symbolTable[name] = symbol
+	}
+	return symbol
+}
+
+// names listed by .globl/.local/.weak/.hidden/... which all take one or more comma separated symbols
+func symbol_names(op_split []string) ([]string, error) {
+	names, err := data_operands(op_split)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if !is_symbol_name(name) {
+			return nil, fmt.Errorf("%s: %q is not a symbol name", op_split[0], name)
+		}
+	}
+	return names, nil
+}
+
+func is_symbol_name(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !is_ident_char(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// .weak, .hidden, .protected, .internal
+func set_symbol_binding(op_split []string) error {
+	names, err := symbol_names(op_split)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		symbol := symbol_attrs(name)
+		switch op_split[0] {
+		case ".weak":
+			symbol.weak = true
+		case ".hidden":
+			symbol.vis = VisHidden
+		case ".protected":
+			symbol.vis = VisProtected
+		case ".internal":
+			symbol.vis = VisInternal
+		}
+	}
+	return nil
+}
+
+// .type sym, @function|@object|@notype (also %function or "function")
+func set_symbol_type(op_split []string) error {
+	args, err := data_operands(op_split)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 || !is_symbol_name(args[0]) {
+		return fmt.Errorf(".type takes sym, @function|@object")
+	}
+	var typ SymType
+	switch strings.Trim(strings.TrimLeft(args[1], "@%"), "\"") {
+	case "function", "STT_FUNC":
+		typ = SymFunc
+	case "object", "STT_OBJECT":
+		typ = SymObject
+	case "notype", "STT_NOTYPE":
+		typ = SymNoType
+	default:
+		return fmt.Errorf(".type %s: unsupported symbol type %s", args[0], args[1])
+	}
+	symbol_attrs(args[0]).typ = typ
+	return nil
+}
+
+// .size sym, expr. Expressions like . - sym need final addresses, so the size is only stored when final is set
+func set_symbol_size(op_split []string, curr_idx int, final bool) error {
+	args, err := data_operands(op_split)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 || !is_symbol_name(args[0]) {
+		return fmt.Errorf(".size takes sym, size")
+	}
+	symbol := symbol_attrs(args[0])
+	if !final {
+		return nil
+	}
+	size, err := eval_expr(args[1], curr_idx)
+	if err != nil {
+		return fmt.Errorf(".size %s: %w", args[0], err)
+	}
+	if size < 0 {
+		return fmt.Errorf(".size %s is negative (%d)", args[0], size)
+	}
+	symbol.size = ilen(size)
+	return nil
+}
+
+// .equ/.set sym, expr and sym = expr. The symbol can be reassigned and every line sees the value from the last
+// assignment before it, so the second pass repeats the assignments in order. On the first pass labels may not
+// be placed yet, so a value that cannot be computed is left for the second pass (final)
+func set_symbol_value(name string, expr string, curr_idx int, final bool) error {
+	name = strings.TrimSpace(name)
+	if !is_symbol_name(name) {
+		return fmt.Errorf("%q is not a symbol name", name)
+	}
+	val, err := eval_expr(strings.TrimSpace(expr), curr_idx)
+	if err != nil {
+		if final {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return nil
+	}
+	valueTable[name] = val
+	return nil
+}
+
+// evaluates every assignment once now that labels have their final addresses, so lines that use a symbol
+// before its assignment still see an address rather than a section offset
+func settle_assignments(lines []string) {
+	for i, line := range lines {
+		op_split := strings.SplitN(line, " ", 2)
+		if key, val, ok := parse_assignment(line); ok {
+			set_symbol_value(key, val, i, false)
+		} else if op_split[0] == ".equ" || op_split[0] == ".set" {
+			if key, val, err := assignment_operands(op_split); err == nil {
+				set_symbol_value(key, val, i, false)
+			}
+		}
+	}
+}
+
+// .equ/.set operands
+func assignment_operands(op_split []string) (string, string, error) {
+	if len(op_split) < 2 {
+		return "", "", fmt.Errorf("%s takes sym, value", op_split[0])
+	}
+	args := strings.SplitN(op_split[1], ",", 2)
+	if len(args) != 2 {
+		return "", "", fmt.Errorf("%s takes sym, value", op_split[0])
+	}
+	return args[0], args[1], nil
+}
+
+var assignment = regexp.MustCompile(`^([A-Za-z_.$][A-Za-z0-9_.$]*) ?=([^=].*)$`)
+
+// splits "sym = expr" lines
+func parse_assignment(line string) (string, string, bool) {
+	match := assignment.FindStringSubmatch(line)
+	if match == nil || match[1] == "." {
+		return "", "", false
+	}
+	return match[1], match[2], true
+}
+
+// text for Print_Info, e.g. "global func hidden size=12"
+func (sym *Symbol) attr_string() string {
+	out := "local"
+	if sym.weak {
+		out = "weak"
+	} else if sym.global {
+		out = "global"
+	}
+	switch sym.typ {
+	case SymFunc:
+		out += " func"
+	case SymObject:
+		out += " object"
+	}
+	switch sym.vis {
+	case VisInternal:
+		out += " internal"
+	case VisHidden:
+		out += " hidden"
+	case VisProtected:
+		out += " protected"
+	}
+	if sym.size != 0 {
+		out += fmt.Sprintf(" size=%d", sym.size)
+	}
+	return out
+}
@@ -0,0 +1,136 @@
+package assembler
+
+import (
+	"bytes"
+	"debug/elf"
+	"strings"
+	"testing"
+)
+
+// symbols of the ELF WriteELF makes from the last assembly, by name
+func elf_symbols(t *testing.T) map[string]elf.Symbol {
+	t.Helper()
+	syms, err := write_elf(t).Symbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]elf.Symbol)
+	for _, sym := range syms {
+		out[sym.Name] = sym
+	}
+	return out
+}
+
+func TestSymbolAttributes(t *testing.T) {
+	assemble_image(t, []string{
+		".globl f", ".type f, @function",
+		"f:", "addi zero, zero, 0", "addi zero, zero, 0",
+		".size f, . - f",
+		".data",
+		".type obj, %object",
+		"obj:", ".word 1, 2",
+		".size obj, 8",
+		".weak w", "w:", ".byte 0",
+		".globl h", ".hidden h", "h:", ".byte 0",
+		".protected p", "p:", ".byte 0",
+		".equ ten, 10",
+	})
+	syms := elf_symbols(t)
+	cases := []struct {
+		name  string
+		bind  elf.SymBind
+		typ   elf.SymType
+		vis   elf.SymVis
+		value uint64
+		size  uint64
+	}{
+		{"f", elf.STB_GLOBAL, elf.STT_FUNC, elf.STV_DEFAULT, 0, 8},
+		{"obj", elf.STB_LOCAL, elf.STT_OBJECT, elf.STV_DEFAULT, 8, 8},
+		{"w", elf.STB_WEAK, elf.STT_NOTYPE, elf.STV_DEFAULT, 16, 0},
+		{"h", elf.STB_GLOBAL, elf.STT_NOTYPE, elf.STV_HIDDEN, 17, 0},
+		{"p", elf.STB_LOCAL, elf.STT_NOTYPE, elf.STV_PROTECTED, 18, 0},
+		{"ten", elf.STB_LOCAL, elf.STT_NOTYPE, elf.STV_DEFAULT, 10, 0},
+	}
+	for _, c := range cases {
+		sym, ok := syms[c.name]
+		if !ok {
+			t.Errorf("%s is not in the symbol table", c.name)
+			continue
+		}
+		if elf.ST_BIND(sym.Info) != c.bind || elf.ST_TYPE(sym.Info) != c.typ || elf.ST_VISIBILITY(sym.Other) != c.vis || sym.Value != c.value || sym.Size != c.size {
+			t.Errorf("%s: %v %v %v value %d size %d, want %v %v %v value %d size %d", c.name,
+				elf.ST_BIND(sym.Info), elf.ST_TYPE(sym.Info), elf.ST_VISIBILITY(sym.Other), sym.Value, sym.Size,
+				c.bind, c.typ, c.vis, c.value, c.size)
+		}
+	}
+	if syms["ten"].Section != elf.SHN_ABS {
+		t.Errorf("ten in section %v, want SHN_ABS", syms["ten"].Section)
+	}
+}
+
+// .set and = can be reassigned, every line sees the last assignment before it, and a value may use labels
+// that come later
+func TestSetSymbols(t *testing.T) {
+	got := assemble_image(t, []string{
+		".set x, 1", ".word x",
+		".set x, 2", ".word x",
+		"y = x + 1", ".word y",
+		"x = x * 10", ".word x",
+		".word len",
+		"start:", "addi zero, zero, 0", "end:",
+		".equ len, end - start",
+	})
+	want := assemble_image(t, []string{".word 1, 2, 3, 20, 4", "addi zero, zero, 0"})
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %x\nwant %x", got, want)
+	}
+	// values are kept at 64 bits, each use checks they fit
+	got = assemble_image(t, []string{".equ BIG, 0x100000000", ".equ M, 0xffffffff", ".dword BIG, M", ".word M"})
+	want = assemble_image(t, []string{".dword 0x100000000, 0xffffffff", ".word 0xffffffff"})
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %x\nwant %x", got, want)
+	}
+	for _, line := range []string{".word BIG", "addi a0, zero, M"} {
+		if _, err := Assemble([]string{".equ BIG, 0x100000000", ".equ M, 0xffffffff", line}); err == nil {
+			t.Errorf("%q was accepted", line)
+		}
+	}
+}
+
+func TestSymbolDirectiveRejects(t *testing.T) {
+	for _, lines := range [][]string{
+		{".type f, @gnu_indirect_function"},
+		{".type f"},
+		{".type 1f, @function"},
+		{".size f"},
+		{"f:", ".size f, -1"},
+		{".size f, undefined_sym"},
+		{".weak 9x"},
+		{".set 1x, 2"},
+		{".globl"},
+	} {
+		if _, err := Assemble(lines); err == nil {
+			t.Errorf("%q was accepted", lines)
+		}
+	}
+}
+
+func TestUndefinedGlobal(t *testing.T) {
+	if _, err := Assemble([]string{".globl extern_fn", "addi zero, zero, 0", "call extern_fn"}); err == nil || !strings.Contains(err.Error(), "undefined symbol") {
+		t.Errorf("call to an undefined global: %v", err)
+	}
+	if _, err := Assemble([]string{".globl extern_fn", "addi zero, zero, 0"}); err != nil {
+		t.Fatal(err)
+	}
+	sym := elf_symbols(t)["extern_fn"]
+	if sym.Section != elf.SHN_UNDEF || elf.ST_BIND(sym.Info) != elf.STB_GLOBAL {
+		t.Errorf("extern_fn: section %v bind %v, want SHN_UNDEF global", sym.Section, elf.ST_BIND(sym.Info))
+	}
+	// a label after the .globl still defines it
+	if _, err := Assemble([]string{".globl f", "addi zero, zero, 0", "f:", "addi zero, zero, 0"}); err != nil {
+		t.Fatal(err)
+	}
+	if sym := elf_symbols(t)["f"]; sym.Section == elf.SHN_UNDEF || sym.Value != 4 {
+		t.Errorf("f: section %v value %d, want .text at 4", sym.Section, sym.Value)
+	}
+}
@@ -0,0 +1,176 @@
+package assembler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// -fsyscalls: a built-in set of SYS_* constants that every file starts out with, as if it began with .equ
+// lines for them (addi a7, zero, SYS_write). A file can still .equ them itself. Empty means none
+var Syscalls = ""
+
+// Linux system call numbers on RV32 (asm-generic/unistd.h). RV32 only has the 64-bit time variants, so
+// there is no SYS_futex or SYS_nanosleep but SYS_futex_time64 and SYS_clock_nanosleep_time64
+var linuxRISCVSyscalls = map[string]int64{
+	"SYS_getcwd":                 17,
+	"SYS_eventfd2":               19,
+	"SYS_epoll_create1":          20,
+	"SYS_epoll_ctl":              21,
+	"SYS_dup":                    23,
+	"SYS_dup3":                   24,
+	"SYS_fcntl64":                25,
+	"SYS_ioctl":                  29,
+	"SYS_flock":                  32,
+	"SYS_mknodat":                33,
+	"SYS_mkdirat":                34,
+	"SYS_unlinkat":               35,
+	"SYS_symlinkat":              36,
+	"SYS_linkat":                 37,
+	"SYS_umount2":                39,
+	"SYS_mount":                  40,
+	"SYS_statfs64":               43,
+	"SYS_fstatfs64":              44,
+	"SYS_truncate64":             45,
+	"SYS_ftruncate64":            46,
+	"SYS_faccessat":              48,
+	"SYS_chdir":                  49,
+	"SYS_fchdir":                 50,
+	"SYS_chroot":                 51,
+	"SYS_fchmod":                 52,
+	"SYS_fchmodat":               53,
+	"SYS_fchownat":               54,
+	"SYS_fchown":                 55,
+	"SYS_openat":                 56,
+	"SYS_close":                  57,
+	"SYS_pipe2":                  59,
+	"SYS_getdents64":             61,
+	"SYS_llseek":                 62,
+	"SYS_read":                   63,
+	"SYS_write":                  64,
+	"SYS_readv":                  65,
+	"SYS_writev":                 66,
+	"SYS_pread64":                67,
+	"SYS_pwrite64":               68,
+	"SYS_sendfile64":             71,
+	"SYS_readlinkat":             78,
+	"SYS_sync":                   81,
+	"SYS_fsync":                  82,
+	"SYS_fdatasync":              83,
+	"SYS_exit":                   93,
+	"SYS_exit_group":             94,
+	"SYS_waitid":                 95,
+	"SYS_set_tid_address":        96,
+	"SYS_unshare":                97,
+	"SYS_set_robust_list":        99,
+	"SYS_sched_yield":            124,
+	"SYS_kill":                   129,
+	"SYS_tkill":                  130,
+	"SYS_tgkill":                 131,
+	"SYS_sigaltstack":            132,
+	"SYS_rt_sigaction":           134,
+	"SYS_rt_sigprocmask":         135,
+	"SYS_rt_sigreturn":           139,
+	"SYS_setpriority":            140,
+	"SYS_getpriority":            141,
+	"SYS_reboot":                 142,
+	"SYS_setregid":               143,
+	"SYS_setgid":                 144,
+	"SYS_setreuid":               145,
+	"SYS_setuid":                 146,
+	"SYS_times":                  153,
+	"SYS_setpgid":                154,
+	"SYS_getpgid":                155,
+	"SYS_getsid":                 156,
+	"SYS_setsid":                 157,
+	"SYS_uname":                  160,
+	"SYS_umask":                  166,
+	"SYS_prctl":                  167,
+	"SYS_getcpu":                 168,
+	"SYS_getpid":                 172,
+	"SYS_getppid":                173,
+	"SYS_getuid":                 174,
+	"SYS_geteuid":                175,
+	"SYS_getgid":                 176,
+	"SYS_getegid":                177,
+	"SYS_gettid":                 178,
+	"SYS_sysinfo":                179,
+	"SYS_socket":                 198,
+	"SYS_socketpair":             199,
+	"SYS_bind":                   200,
+	"SYS_listen":                 201,
+	"SYS_accept":                 202,
+	"SYS_connect":                203,
+	"SYS_getsockname":            204,
+	"SYS_getpeername":            205,
+	"SYS_sendto":                 206,
+	"SYS_recvfrom":               207,
+	"SYS_setsockopt":             208,
+	"SYS_getsockopt":             209,
+	"SYS_shutdown":               210,
+	"SYS_sendmsg":                211,
+	"SYS_recvmsg":                212,
+	"SYS_brk":                    214,
+	"SYS_munmap":                 215,
+	"SYS_mremap":                 216,
+	"SYS_clone":                  220,
+	"SYS_execve":                 221,
+	"SYS_mmap2":                  222,
+	"SYS_mprotect":               226,
+	"SYS_msync":                  227,
+	"SYS_mincore":                232,
+	"SYS_madvise":                233,
+	"SYS_accept4":                242,
+	"SYS_riscv_hwprobe":          258,
+	"SYS_riscv_flush_icache":     259,
+	"SYS_prlimit64":              261,
+	"SYS_renameat2":              276,
+	"SYS_seccomp":                277,
+	"SYS_getrandom":              278,
+	"SYS_memfd_create":           279,
+	"SYS_execveat":               281,
+	"SYS_statx":                  291,
+	"SYS_clock_gettime64":        403,
+	"SYS_clock_settime64":        404,
+	"SYS_clock_adjtime64":        405,
+	"SYS_clock_getres_time64":    406,
+	"SYS_clock_nanosleep_time64": 407,
+	"SYS_futex_time64":           422,
+	"SYS_pidfd_send_signal":      424,
+	"SYS_pidfd_open":             434,
+	"SYS_clone3":                 435,
+	"SYS_close_range":            436,
+	"SYS_faccessat2":             439,
+}
+
+var syscallSets = map[string]map[string]int64{
+	"linux-riscv": linuxRISCVSyscalls,
+}
+
+// SetSyscalls checks a -fsyscalls name and makes it the current set
+func SetSyscalls(name string) error {
+	if _, ok := syscallSets[name]; !ok && name != "" {
+		sets := make([]string, 0, len(syscallSets))
+		for set := range syscallSets {
+			sets = append(sets, set)
+		}
+		sort.Strings(sets)
+		return fmt.Errorf("-fsyscalls: unknown set %q, use %s", name, strings.Join(sets, " or "))
+	}
+	Syscalls = name
+	return nil
+}
+
+// puts the current set into valueTable before the first line is laid out
+func predefine_syscalls() {
+	for name, num := range syscallSets[Syscalls] {
+		valueTable[name] = num
+	}
+}
+
+// whether a valueTable entry is still the untouched built-in constant, which stays out of the ELF symbol table
+func is_predefined_value(name string) bool {
+	num, ok := syscallSets[Syscalls][name]
+	_, declared := symbolTable[name]
+	return ok && !declared && valueTable[name] == num
+}
@@ -0,0 +1,58 @@
+package assembler
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSyscalls(t *testing.T) {
+	if _, err := Assemble([]string{"addi a7, zero, SYS_write"}); err == nil {
+		t.Error("SYS_write is defined without -fsyscalls")
+	}
+	if err := SetSyscalls("linux-riscv"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetSyscalls("") })
+	if got := assemble_word(t, "addi a7, zero, SYS_write"); got != 0x04000893 {
+		t.Errorf("addi a7, zero, SYS_write = %08x, want 04000893", got)
+	}
+	if got := assemble_word(t, "addi a7, zero, SYS_exit_group - SYS_exit + 1"); got != 0x00200893 {
+		t.Errorf("expression = %08x, want 00200893", got)
+	}
+	image, err := Assemble([]string{".equ SYS_write, 1", "addi a7, zero, SYS_write"})
+	if err != nil || binary.LittleEndian.Uint32(image) != 0x00100893 {
+		t.Errorf(".equ over SYS_write = %x, %v, want 00100893", image, err)
+	}
+
+	// only constants the file changed end up in the symbol table
+	if _, err := Assemble([]string{".equ SYS_exit, 7", "addi a7, zero, SYS_write", "ecall"}); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "sys.elf")
+	WriteELF(filename)
+	f, err := elf.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	syms, err := f.Symbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, sym := range syms {
+		if strings.HasPrefix(sym.Name, "SYS_") {
+			names = append(names, sym.Name)
+		}
+	}
+	if len(names) != 1 || names[0] != "SYS_exit" {
+		t.Errorf("SYS_ symbols %v, want [SYS_exit]", names)
+	}
+
+	if err := SetSyscalls("linux-x86"); err == nil {
+		t.Error("-fsyscalls=linux-x86 was accepted")
+	}
+}
@@ -0,0 +1,247 @@
+package assembler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SYSTEM instructions with operands: CSR accesses (rd, csr, rs1/zimm), fences (rs1, rs2) and the hypervisor
+// loads/stores, which take their address as (rs1) like vector ones. CSRs are written by name or number
+
+// a CSR and the extension its name needs. Numbers are always accepted
+type csr_info struct {
+	num uint32
+	ext InstrExt
+}
+
+var csrNames = map[string]csr_info{
+	// unprivileged
+	"fflags":   {0x001, ExtF},
+	"frm":      {0x002, ExtF},
+	"fcsr":     {0x003, ExtF},
+	"cycle":    {0xC00, ExtNone},
+	"time":     {0xC01, ExtNone},
+	"instret":  {0xC02, ExtNone},
+	"cycleh":   {0xC80, ExtNone},
+	"timeh":    {0xC81, ExtNone},
+	"instreth": {0xC82, ExtNone},
+	// supervisor
+	"sstatus":    {0x100, ExtNone},
+	"sie":        {0x104, ExtNone},
+	"stvec":      {0x105, ExtNone},
+	"scounteren": {0x106, ExtNone},
+	"senvcfg":    {0x10A, ExtNone},
+	"sscratch":   {0x140, ExtNone},
+	"sepc":       {0x141, ExtNone},
+	"scause":     {0x142, ExtNone},
+	"stval":      {0x143, ExtNone},
+	"sip":        {0x144, ExtNone},
+	"satp":       {0x180, ExtNone},
+	// hypervisor
+	"hstatus":     {0x600, ExtH},
+	"hedeleg":     {0x602, ExtH},
+	"hideleg":     {0x603, ExtH},
+	"hie":         {0x604, ExtH},
+	"htimedelta":  {0x605, ExtH},
+	"hcounteren":  {0x606, ExtH},
+	"hgeie":       {0x607, ExtH},
+	"henvcfg":     {0x60A, ExtH},
+	"htimedeltah": {0x615, ExtH},
+	"henvcfgh":    {0x61A, ExtH},
+	"htval":       {0x643, ExtH},
+	"hip":         {0x644, ExtH},
+	"hvip":        {0x645, ExtH},
+	"htinst":      {0x64A, ExtH},
+	"hgatp":       {0x680, ExtH},
+	"hgeip":       {0xE12, ExtH},
+	// virtual supervisor
+	"vsstatus":  {0x200, ExtH},
+	"vsie":      {0x204, ExtH},
+	"vstvec":    {0x205, ExtH},
+	"vsscratch": {0x240, ExtH},
+	"vsepc":     {0x241, ExtH},
+	"vscause":   {0x242, ExtH},
+	"vstval":    {0x243, ExtH},
+	"vsip":      {0x244, ExtH},
+	"vsatp":     {0x280, ExtH},
+	// machine
+	"mvendorid":  {0xF11, ExtNone},
+	"marchid":    {0xF12, ExtNone},
+	"mimpid":     {0xF13, ExtNone},
+	"mhartid":    {0xF14, ExtNone},
+	"mconfigptr": {0xF15, ExtNone},
+	"mstatus":    {0x300, ExtNone},
+	"misa":       {0x301, ExtNone},
+	"medeleg":    {0x302, ExtNone},
+	"mideleg":    {0x303, ExtNone},
+	"mie":        {0x304, ExtNone},
+	"mtvec":      {0x305, ExtNone},
+	"mcounteren": {0x306, ExtNone},
+	"menvcfg":    {0x30A, ExtNone},
+	"mstatush":   {0x310, ExtNone},
+	"menvcfgh":   {0x31A, ExtNone},
+	"mscratch":   {0x340, ExtNone},
+	"mepc":       {0x341, ExtNone},
+	"mcause":     {0x342, ExtNone},
+	"mtval":      {0x343, ExtNone},
+	"mip":        {0x344, ExtNone},
+	"mtinst":     {0x34A, ExtNone},
+	"mtval2":     {0x34B, ExtNone},
+	"mcycle":     {0xB00, ExtNone},
+	"minstret":   {0xB02, ExtNone},
+	"mcycleh":    {0xB80, ExtNone},
+	"minstreth":  {0xB82, ExtNone},
+}
+
+// ecall/ebreak have no operands and stay I
+func (desc InstrDesc) is_system() bool {
+	return desc.match&0x7F == uint32(C) && len(desc.args) > 0
+}
+
+// the hypervisor loads/stores, the only SYSTEM instructions with funct3 4
+func is_system_mem(desc InstrDesc) bool {
+	return (desc.match>>12)&0x7 == 4
+}
+
+// the fields of a SYSTEM instruction in the order they are written
+func system_operands(desc InstrDesc) []string {
+	switch {
+	case is_system_mem(desc) && desc.has_arg("rd"): //hlv.w rd, (rs1)
+		return []string{"rd", "mem"}
+	case is_system_mem(desc): //hsv.w rs2, (rs1)
+		return []string{"rs2", "mem"}
+	}
+	var order []string
+	for _, field := range []string{"rd", "csr", "rs1", "zimm", "rs2"} {
+		if desc.has_arg(field) {
+			order = append(order, field)
+		}
+	}
+	return order
+}
+
+// a CSR name the current ISA allows, or a number
+func csr_operand(op string, curr_idx int) (int64, error) {
+	if info, ok := csrNames[strings.ToLower(op)]; ok {
+		if info.ext != ExtNone && activeISA.exts&info.ext == 0 {
+			return 0, fmt.Errorf("CSR %s needs extension %s, which %s does not include", op, ext_names(info.ext), activeISA.src)
+		}
+		return int64(info.num), nil
+	}
+	num, err := eval_expr(op, curr_idx)
+	if err != nil {
+		return 0, fmt.Errorf("unknown CSR %q", op)
+	}
+	if num < 0 || num > 0xFFF {
+		return 0, fmt.Errorf("CSR number %d does not fit in 12 bits", num)
+	}
+	return num, nil
+}
+
+// csrr rd, csr reads a CSR. csrw/csrs/csrc csr, rs1 and their i forms write it and throw the old value away
+var csrAliases = map[string]string{
+	"csrr":  "csrrs",
+	"csrw":  "csrrw",
+	"csrs":  "csrrs",
+	"csrc":  "csrrc",
+	"csrwi": "csrrwi",
+	"csrsi": "csrrsi",
+	"csrci": "csrrci",
+}
+
+// rewrites a CSR alias as the instruction it stands for, so it goes through the same CSR name lookup
+func expand_csr_alias(op_split []string) ([]string, error) {
+	base, ok := csrAliases[op_split[0]]
+	if !ok {
+		return op_split, nil
+	}
+	operands := []string{}
+	if len(op_split) == 2 {
+		operands = split_operands(op_split[1])
+	}
+	if op_split[0] == "csrr" {
+		if len(operands) != 2 {
+			return nil, fmt.Errorf("csrr takes rd, csr")
+		}
+		return []string{base, operands[0] + ", " + operands[1] + ", zero"}, nil
+	}
+	if len(operands) != 2 {
+		return nil, fmt.Errorf("%s takes csr, %s", op_split[0], system_operands(InstrTable[base])[2])
+	}
+	return []string{base, "zero, " + operands[0] + ", " + operands[1]}, nil
+}
+
+// name of a CSR when the current ISA has it, its number otherwise
+func csr_name(num uint32) string {
+	for name, info := range csrNames {
+		if info.num == num && (info.ext == ExtNone || activeISA.exts&info.ext != 0) {
+			return name
+		}
+	}
+	return fmt.Sprintf("%d", num)
+}
+
+func encode_system(curr_idx int, mnemonic string, itype InstrDesc, operands []string) (ilen, error) {
+	fields := system_operands(itype)
+	if err := operand_count(mnemonic, operands, len(fields)); err != nil {
+		return 0, err
+	}
+	word := itype.match
+	for i, field := range fields {
+		var val int64
+		var err error
+		switch field {
+		case "rd", "rs1", "rs2":
+			var reg uint8
+			reg, err = reg_operand(operands[i])
+			val = int64(reg)
+		case "mem":
+			var reg uint8
+			val, reg, err = mem_operand(operands[i], curr_idx)
+			if err == nil && val != 0 {
+				err = fmt.Errorf("%s: hypervisor loads and stores take no offset, got %d", mnemonic, val)
+			}
+			val, field = int64(reg), "rs1"
+		case "csr":
+			val, err = csr_operand(operands[i], curr_idx)
+			if err != nil {
+				err = fmt.Errorf("%s: %w", mnemonic, err)
+			}
+		case "zimm":
+			val, _, err = imm_operand(operands[i], curr_idx)
+			if err == nil {
+				err = check_imm(mnemonic, val, vuRange)
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+		f := operandFields[field]
+		word |= uint32(val) << f.lo & field_mask(f)
+	}
+	return ilen(word), nil
+}
+
+// text of a SYSTEM instruction, in the same operand order encode_system takes
+func decode_system(name string, desc InstrDesc, word uint32) string {
+	var ops []string
+	for _, field := range system_operands(desc) {
+		lookup := field
+		if field == "mem" {
+			lookup = "rs1"
+		}
+		f := operandFields[lookup]
+		val := (word & field_mask(f)) >> f.lo
+		switch field {
+		case "rd", "rs1", "rs2":
+			ops = append(ops, reg_name(val))
+		case "mem":
+			ops = append(ops, fmt.Sprintf("(%s)", reg_name(val)))
+		case "csr":
+			ops = append(ops, csr_name(val))
+		case "zimm":
+			ops = append(ops, fmt.Sprintf("%d", val))
+		}
+	}
+	return name + " " + strings.Join(ops, ", ")
+}
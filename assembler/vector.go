@@ -0,0 +1,212 @@
+package assembler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Vector instructions do not share one operand layout the way R/I/S/B/U/J ones do, so the order they are
+// written in follows from their fields: destination, (rs1) for loads/stores, vs2, then vs1/rs1/rs2 or an
+// immediate, the vtype of vsetvli/vsetivli, and an optional v0.t when the instruction has a vm bit
+var vectorOperandOrder = []string{"rd", "vd", "vs3", "mem", "vs2", "vs1", "rs1", "rs2", "simm5", "zimm5", "zimm11", "zimm10"}
+
+// fields only vector instructions have
+var vectorFields = map[string]bool{
+	"vd": true, "vs1": true, "vs2": true, "vs3": true, "vm": true, "simm5": true, "zimm5": true, "zimm10": true, "zimm11": true,
+}
+
+// vlmul encodings, 4 is reserved
+var vlmulNames = []string{"m1", "m2", "m4", "m8", "", "mf8", "mf4", "mf2"}
+
+func (desc InstrDesc) is_vector() bool {
+	for _, arg := range desc.args {
+		if vectorFields[arg] {
+			return true
+		}
+	}
+	return false
+}
+
+// vector loads and stores live on the LOAD-FP/STORE-FP opcodes and write rs1 as (rs1)
+func is_vector_mem(desc InstrDesc) bool {
+	return desc.Opcode == 0x07 || desc.Opcode == 0x27
+}
+
+// the fields of a vector instruction in the order they are written, without vm
+func vector_operands(desc InstrDesc) []string {
+	var order []string
+	for _, field := range vectorOperandOrder {
+		switch {
+		case field == "mem":
+			if is_vector_mem(desc) {
+				order = append(order, field)
+			}
+		case field == "rs1" && is_vector_mem(desc): //written as mem
+		case desc.has_arg(field):
+			order = append(order, field)
+		}
+	}
+	return order
+}
+
+func vreg_operand(name string) (uint8, error) {
+	num, ok := strings.CutPrefix(name, "v")
+	reg, err := strconv.ParseUint(num, 10, 5)
+	if !ok || err != nil || num != strconv.FormatUint(reg, 10) {
+		return 0, fmt.Errorf("unknown vector register %q", name)
+	}
+	return uint8(reg), nil
+}
+
+// vtype as e8..e64, m1..m8/mf2..mf8, ta/tu and ma/mu (only the element width is required), or a number
+func vtype_operand(op string, curr_idx int) (int64, error) {
+	parts := strings.Split(op, ",")
+	if len(parts) == 1 && !strings.HasPrefix(strings.TrimSpace(op), "e") {
+		return eval_expr(op, curr_idx)
+	}
+	sew, lmul, ta, ma := int64(-1), int64(0), int64(0), int64(0)
+	seen := map[byte]bool{}
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		kind := byte(0)
+		switch part {
+		case "e8", "e16", "e32", "e64":
+			kind = 'e'
+			width, _ := strconv.Atoi(part[1:])
+			sew = map[int]int64{8: 0, 16: 1, 32: 2, 64: 3}[width]
+		case "ta", "tu":
+			kind, ta = 't', map[bool]int64{true: 1}[part == "ta"]
+		case "ma", "mu":
+			kind, ma = 'a', map[bool]int64{true: 1}[part == "ma"]
+		default:
+			for enc, name := range vlmulNames {
+				if name != "" && part == name {
+					kind, lmul = 'm', int64(enc)
+				}
+			}
+		}
+		if kind == 0 {
+			return 0, fmt.Errorf("unknown vtype field %q", part)
+		}
+		if seen[kind] {
+			return 0, fmt.Errorf("vtype %q gives %s twice", op, part)
+		}
+		seen[kind] = true
+	}
+	if sew < 0 {
+		return 0, fmt.Errorf("vtype %q has no element width (e8..e64)", op)
+	}
+	return lmul | sew<<3 | ta<<6 | ma<<7, nil
+}
+
+// vtype the way vtype_operand takes it. Reserved encodings stay numbers
+func vtype_string(vtype uint32) string {
+	lmul, sew := vtype&0x7, (vtype>>3)&0x7
+	if vtype>>8 != 0 || lmul == 4 || sew > 3 {
+		return fmt.Sprintf("%d", vtype)
+	}
+	policy := []string{"tu", "mu"}
+	if vtype&0x40 != 0 {
+		policy[0] = "ta"
+	}
+	if vtype&0x80 != 0 {
+		policy[1] = "ma"
+	}
+	return fmt.Sprintf("e%d, %s, %s, %s", 8<<sew, vlmulNames[lmul], policy[0], policy[1])
+}
+
+func encode_vector(curr_idx int, mnemonic string, itype InstrDesc, operands []string) (ilen, error) {
+	fields := vector_operands(itype)
+	vm := uint32(1) //unmasked
+	if itype.has_arg("vm") && len(operands) > 0 && operands[len(operands)-1] == "v0.t" {
+		vm = 0
+		operands = operands[:len(operands)-1]
+	}
+	// the vtype of vsetvli/vsetivli is comma separated itself, so it takes the rest of the line
+	if n := len(fields); n > 0 && strings.HasPrefix(fields[n-1], "zimm1") && len(operands) > n {
+		operands = append(operands[:n-1], strings.Join(operands[n-1:], ", "))
+	}
+	if err := operand_count(mnemonic, operands, len(fields)); err != nil {
+		return 0, err
+	}
+	word := itype.match
+	if itype.has_arg("vm") {
+		word |= vm << 25
+	}
+	for i, field := range fields {
+		var val int64
+		var err error
+		switch field {
+		case "rd", "rs1", "rs2":
+			var reg uint8
+			reg, err = reg_operand(operands[i])
+			val = int64(reg)
+		case "vd", "vs1", "vs2", "vs3":
+			var reg uint8
+			reg, err = vreg_operand(operands[i])
+			val = int64(reg)
+		case "mem":
+			var reg uint8
+			val, reg, err = mem_operand(operands[i], curr_idx)
+			if err == nil && val != 0 {
+				err = fmt.Errorf("%s: vector loads and stores take no offset, got %d", mnemonic, val)
+			}
+			val, field = int64(reg), "rs1"
+		case "simm5", "zimm5":
+			val, _, err = imm_operand(operands[i], curr_idx)
+			if err == nil {
+				r := vsRange
+				if field == "zimm5" {
+					r = vuRange
+				}
+				err = check_imm(mnemonic, val, r)
+			}
+		case "zimm10", "zimm11":
+			val, err = vtype_operand(operands[i], curr_idx)
+			if f := operandFields[field]; err == nil && (val < 0 || val > int64(field_mask(f)>>f.lo)) {
+				err = fmt.Errorf("%s: vtype %d does not fit in %d bits", mnemonic, val, f.hi-f.lo+1)
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+		f := operandFields[field]
+		word |= uint32(val) << f.lo & field_mask(f)
+	}
+	return ilen(word), nil
+}
+
+// text of a vector instruction, in the same operand order encode_vector takes
+func decode_vector(name string, desc InstrDesc, word uint32) string {
+	var ops []string
+	for _, field := range vector_operands(desc) {
+		lookup := field
+		if field == "mem" {
+			lookup = "rs1"
+		}
+		f := operandFields[lookup]
+		val := (word & field_mask(f)) >> f.lo
+		switch field {
+		case "rd", "rs1", "rs2":
+			ops = append(ops, reg_name(val))
+		case "vd", "vs1", "vs2", "vs3":
+			ops = append(ops, fmt.Sprintf("v%d", val))
+		case "mem":
+			ops = append(ops, fmt.Sprintf("(%s)", reg_name(val)))
+		case "simm5":
+			ops = append(ops, fmt.Sprintf("%d", int32(val<<27)>>27))
+		case "zimm5":
+			ops = append(ops, fmt.Sprintf("%d", val))
+		case "zimm10", "zimm11":
+			ops = append(ops, vtype_string(val))
+		}
+	}
+	if desc.has_arg("vm") && word&(1<<25) == 0 {
+		ops = append(ops, "v0.t")
+	}
+	if len(ops) == 0 {
+		return name
+	}
+	return name + " " + strings.Join(ops, ", ")
+}
@@ -0,0 +1,34 @@
+package assembler
+
+import "testing"
+
+func TestVectorRejects(t *testing.T) {
+	enable_all_exts(t)
+	for _, line := range []string{
+		"vadd.vi v1, v2, 16",
+		"vsll.vi v1, v2, -1",
+		"vadd.vv v1, v2, x3",
+		"vadd.vv v32, v1, v2",
+		"vadd.vv v1, v2, v3, v1.t",
+		"vmv.v.v v1, v2, v0.t", // no vm bit
+		"vle8.v v1, 4(a0)",
+		"vle8.v v1, a0",
+		"vsetvli a0, a1, m1",
+		"vsetvli a0, a1, e32, e16",
+		"vsetvli a0, a1, e32, m3",
+		"vsetivli a0, 32, e8",
+	} {
+		if _, err := Assemble([]string{line}); err == nil {
+			t.Errorf("%q was accepted", line)
+		}
+	}
+}
+
+func TestVectorGating(t *testing.T) {
+	if _, err := Assemble([]string{"vadd.vv v1, v2, v3"}); err == nil {
+		t.Error("vadd.vv without v")
+	}
+	if _, err := Assemble([]string{`.attribute arch, "rv32iv"`, "vsetvli t0, a0, e32, m2, ta, ma", "vadd.vv v1, v2, v3"}); err != nil {
+		t.Error(err)
+	}
+}
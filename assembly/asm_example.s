@@ -1,7 +1,7 @@
 .org 0x38
 .text # 0x40 aligns to 0x50
 # rando
-.align 128 # 256 bits = 32 bytes
+.align 4 # 2^4 = 16 bytes
 .globl       _start 
 .local      deez
 _start: 
@@ -11,13 +11,13 @@ _start:
     sb t1, 10(a0) # S instruction test
     # tuff
 deez:
-    beq t0, a2, 0b1100010101010                # B instruction test
+    beq t0, a2, -1878                          # B instruction test
     beq t0, a2, msg                            # B instruction label test
-    lui t3, 0b11001000100000001010101110101011 # U instruction test
-    jal t1, 0b11001000100000001010101110101011 # J instruction test
+    lui t3, 0xC880A                            # U instruction test
+    jal t1, 43946                              # J instruction test
     jal t1, half                               # J instruction label test
 
-.section .feet
+.section .feet, "a", @progbits
 wakanda:
     .asciz "testing with spaces" # char is 1 byte. 
 .data # 8 + 8 + 8 + 20 + 8 + 12 = 64
@@ -1,16 +1,54 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"log"
+	"os"
 	"phissembler/assembler"
 )
 
 func main() {
+	no_relax := flag.Bool("no-relax", false, "keep call/la/lui sequences at full length")
+	march := flag.String("march", assembler.Arch, "target ISA string, e.g. rv32imac_zicsr or rv32i_xmyext for a custom extension")
+	syscalls := flag.String("fsyscalls", "", "predefine system call numbers as SYS_* constants: linux-riscv")
+	flag.Func("isa-ext", "YAML file describing a custom extension (repeatable)", assembler.LoadISAExtension)
+	flag.Parse()
+	assembler.Relax = !*no_relax
+	if err := assembler.SetArch(*march); err != nil {
+		log.Fatal(err)
+	}
+	if err := assembler.SetSyscalls(*syscalls); err != nil {
+		log.Fatal(err)
+	}
+
+	if flag.Arg(0) == "disasm" {
+		disasm(flag.Args()[1:])
+		return
+	}
+
 	filename := "assembly/asm_example.s"
 	var file_lines = assembler.ParseFile(filename)
 	bin_sz := assembler.FirstPass(file_lines)
 	fmt.Printf("\nbinary size: 0x%0X \n", bin_sz)
 	assembler.SecondPass(file_lines, bin_sz)
+	assembler.WriteELF("assembly.elf")
 	//assembler.Print_Info()
 	assembler.Print_Bin("assembly.bin")
 }
+
+// phissembler disasm [-base addr] file.elf|file.bin
+func disasm(args []string) {
+	cmd := flag.NewFlagSet("disasm", flag.ExitOnError)
+	base := cmd.Uint64("base", 0, "load address of a flat binary")
+	cmd.Parse(args)
+	if cmd.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: phissembler disasm [-base addr] file.elf|file.bin")
+		os.Exit(2)
+	}
+	listing, err := assembler.DisassembleFile(cmd.Arg(0), *base)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print(listing)
+}